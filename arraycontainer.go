@@ -25,10 +25,26 @@ func (ac *arrayContainer) fillLeastSignificant16bits(x []uint32, i int, mask uin
 	}
 }
 
-func (ac *arrayContainer) getShortIterator() shortIterable {
+func (ac *arrayContainer) getShortIterator() shortPeekable {
 	return &shortIterator{ac.content, 0}
 }
 
+func (ac *arrayContainer) getManyIterator() manyIterable {
+	return &shortManyIterator{ac.content, 0}
+}
+
+func (ac *arrayContainer) getReverseIterator() shortIterable {
+	return &reverseIterator{ac.content, len(ac.content) - 1}
+}
+
+func (ac *arrayContainer) minimum() uint16 {
+	return ac.content[0] // assume not empty
+}
+
+func (ac *arrayContainer) maximum() uint16 {
+	return ac.content[len(ac.content)-1] // assume not empty
+}
+
 // unsafe.Sizeof calculates the memory used by the top level of the slice
 // descriptor - not including the size of the memory referenced by the slice.
 // http://golang.org/pkg/unsafe/#Sizeof
@@ -310,7 +326,7 @@ func (ac *arrayContainer) ior(a container) container {
 	case *arrayContainer:
 		return ac.orArray(x)
 	case *bitmapContainer:
-		return a.ior(ac)
+		return x.orArray(ac) // a belongs to the caller, it must not be modified
 	case *runContainer16:
 		return x.orArray(ac) // alternative x.iorArray(ac) is unlikely to be correct
 	}
@@ -371,6 +387,40 @@ func (ac *arrayContainer) orArray(value2 *arrayContainer) container {
 	return answer
 }
 
+func (ac *arrayContainer) addOffset(x uint16) (container, container) {
+	split := binarySearch(ac.content, uint16(1<<16-int(x)))
+	if split < 0 {
+		split = -split - 1
+	}
+	var lo, hi container
+	if split > 0 {
+		a := newArrayContainerSize(split)
+		for i, v := range ac.content[:split] {
+			a.content[i] = v + x
+		}
+		lo = a
+	}
+	if split < len(ac.content) {
+		a := newArrayContainerSize(len(ac.content) - split)
+		for i, v := range ac.content[split:] {
+			a.content[i] = v + x
+		}
+		hi = a
+	}
+	return lo, hi
+}
+
+func (ac *arrayContainer) lazyIXOR(a container) container {
+	return ac.lazyXOR(a)
+}
+
+func (ac *arrayContainer) lazyXOR(a container) container {
+	if x, ok := a.(*bitmapContainer); ok {
+		return x.lazyXOR(ac)
+	}
+	return ac.xor(a)
+}
+
 func (ac *arrayContainer) lazyorArray(value2 *arrayContainer) container {
 	value1 := ac
 	maxPossibleCardinality := value1.getCardinality() + value2.getCardinality()
@@ -421,6 +471,18 @@ func (ac *arrayContainer) intersects(a container) bool {
 	panic("unsupported container type")
 }
 
+func (ac *arrayContainer) andCardinality(a container) int {
+	switch x := a.(type) {
+	case *arrayContainer:
+		return intersection2by2Cardinality(ac.content, x.content)
+	case *bitmapContainer:
+		return x.andCardinality(ac)
+	case *runContainer16:
+		return x.andCardinality(ac)
+	}
+	panic("unsupported container type")
+}
+
 func (ac *arrayContainer) iand(a container) container {
 	switch x := a.(type) {
 	case *arrayContainer:
@@ -495,7 +557,7 @@ func (ac *arrayContainer) andNot(a container) container {
 	case *bitmapContainer:
 		return ac.andNotBitmap(x)
 	case *runContainer16:
-		return x.andNotArray(ac)
+		return ac.andNotRun16(x)
 	}
 	panic("unsupported container type")
 }
@@ -507,11 +569,41 @@ func (ac *arrayContainer) iandNot(a container) container {
 	case *bitmapContainer:
 		return ac.iandNotBitmap(x)
 	case *runContainer16:
-		return x.andNotArray(ac) // alternative is unlikely to be correct: x.iandNotArray(ac)
+		return ac.iandNotRun16(x)
 	}
 	panic("unsupported container type")
 }
 
+func (ac *arrayContainer) andNotRun16(rc *runContainer16) container {
+	answer := newArrayContainerCapacity(len(ac.content))
+	answer.content = answer.content[:len(ac.content)]
+	answer.content = answer.content[:ac.differenceRun16(rc, answer.content)]
+	return answer
+}
+
+func (ac *arrayContainer) iandNotRun16(rc *runContainer16) container {
+	ac.content = ac.content[:ac.differenceRun16(rc, ac.content)]
+	return ac
+}
+
+// differenceRun16 writes the values of ac that are not in rc to buffer
+// (which may alias ac.content) and returns how many were written.
+func (ac *arrayContainer) differenceRun16(rc *runContainer16, buffer []uint16) int {
+	pos := 0
+	k := 0
+	for _, v := range ac.content {
+		for k < len(rc.iv) && rc.iv[k].last < v {
+			k++
+		}
+		if k < len(rc.iv) && rc.iv[k].start <= v {
+			continue
+		}
+		buffer[pos] = v
+		pos++
+	}
+	return pos
+}
+
 func (ac *arrayContainer) andNotArray(value2 *arrayContainer) container {
 	value1 := ac
 	desiredcapacity := value1.getCardinality()
@@ -701,6 +793,40 @@ func (ac *arrayContainer) getCardinality() int {
 	return len(ac.content)
 }
 
+// indexAtOrAfter returns the position of the first value >= x, or len(ac.content)
+func (ac *arrayContainer) indexAtOrAfter(x int) int {
+	if x > MaxUint16 {
+		return len(ac.content)
+	}
+	i := binarySearch(ac.content, uint16(x))
+	if i < 0 {
+		return -i - 1
+	}
+	return i
+}
+
+func (ac *arrayContainer) getCardinalityInRange(start, end int) int {
+	if start >= end {
+		return 0
+	}
+	return ac.indexAtOrAfter(end) - ac.indexAtOrAfter(start)
+}
+
+func (ac *arrayContainer) containsRange(start, end int) bool {
+	if start >= end {
+		return true
+	}
+	return ac.getCardinalityInRange(start, end) == end-start
+}
+
+func (ac *arrayContainer) intersectsRange(start, end int) bool {
+	if start >= end {
+		return false
+	}
+	i := ac.indexAtOrAfter(start)
+	return i < len(ac.content) && int(ac.content[i]) < end
+}
+
 func (ac *arrayContainer) rank(x uint16) int {
 	answer := binarySearch(ac.content, x)
 	if answer >= 0 {
@@ -807,6 +933,22 @@ func (ac *arrayContainer) toEfficientContainer() container {
 	return ac.toBitmapContainer()
 }
 
+// validate checks that the array is not empty, sorted without duplicates and not too large
+func (ac *arrayContainer) validate() error {
+	if len(ac.content) == 0 {
+		return fmt.Errorf("array container is empty")
+	}
+	if len(ac.content) > arrayDefaultMaxSize {
+		return fmt.Errorf("array container has %d values, more than %d", len(ac.content), arrayDefaultMaxSize)
+	}
+	for i := 1; i < len(ac.content); i++ {
+		if ac.content[i] <= ac.content[i-1] {
+			return fmt.Errorf("array container is not strictly increasing at position %d", i)
+		}
+	}
+	return nil
+}
+
 func (bc *arrayContainer) containerType() contype {
 	return arrayContype
 }
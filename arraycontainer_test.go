@@ -154,6 +154,22 @@ func assertPanic(t *testing.T, f func()) {
 	f()
 }
 
+func TestArrayContainerAndNotRun059(t *testing.T) {
+	Convey("arrayContainer andNot and iandNot with a run container remove the runs from the array", t, func() {
+		rc := newRunContainer16TakeOwnership([]interval16{{start: 4, last: 10}, {start: 100, last: 200}})
+		ac := &arrayContainer{[]uint16{1, 4, 5, 10, 11, 150, 201, 300}}
+		expected := []uint16{1, 11, 201, 300}
+
+		answer := ac.andNot(rc).(*arrayContainer)
+		So(answer.content, ShouldResemble, expected)
+		So(ac.getCardinality(), ShouldEqual, 8)
+		So(rc.getCardinality(), ShouldEqual, 108)
+
+		answer = ac.iandNot(rc).(*arrayContainer)
+		So(answer.content, ShouldResemble, expected)
+	})
+}
+
 func TestArrayContainerNumberOfRuns025(t *testing.T) {
 
 	Convey("arrayContainer's numberOfRuns() function should be correct against the runContainer equivalent",
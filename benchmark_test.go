@@ -282,6 +282,27 @@ func BenchmarkIterateRoaring(b *testing.B) {
 	}
 }
 
+// go test -bench BenchmarkIterate -run -
+func BenchmarkIterateManyRoaring(b *testing.B) {
+	b.StopTimer()
+	r := rand.New(rand.NewSource(0))
+	s := NewBitmap()
+	sz := 150000
+	initsize := 65000
+	for i := 0; i < initsize; i++ {
+		s.Add(uint32(r.Int31n(int32(sz))))
+	}
+	buf := make([]uint32, 256)
+	b.StartTimer()
+	for j := 0; j < b.N; j++ {
+		c9 = uint(0)
+		i := s.ManyIterator()
+		for n := i.NextMany(buf); n != 0; n = i.NextMany(buf) {
+			c9 += uint(n)
+		}
+	}
+}
+
 // go test -bench BenchmarkSparseIterate -run -
 func BenchmarkSparseIterateRoaring(b *testing.B) {
 	b.StopTimer()
@@ -510,3 +531,27 @@ func BenchmarkXorLopsided(b *testing.B) {
 		s.Clone().Xor(x2)
 	}
 }
+
+func benchmarkRankSelect(b *testing.B, cache bool) {
+	b.StopTimer()
+	r := rand.New(rand.NewSource(0))
+	s := NewBitmap()
+	for i := 0; i < 5000; i++ {
+		s.Add(uint32(i)<<16 + uint32(r.Intn(1<<16)))
+	}
+	s.SetCardinalityCache(cache)
+	card := s.GetCardinality()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		s.Rank(uint32(r.Int31()))
+		s.Select(uint32(r.Int63n(int64(card))))
+	}
+}
+
+func BenchmarkRankSelectRoaring(b *testing.B) {
+	benchmarkRankSelect(b, false)
+}
+
+func BenchmarkRankSelectCachedRoaring(b *testing.B) {
+	benchmarkRankSelect(b, true)
+}
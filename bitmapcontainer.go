@@ -60,20 +60,110 @@ func (bcsi *bitmapContainerShortIterator) next() uint16 {
 func (bcsi *bitmapContainerShortIterator) hasNext() bool {
 	return bcsi.i >= 0
 }
+
+func (bcsi *bitmapContainerShortIterator) peekNext() uint16 {
+	return uint16(bcsi.i)
+}
+
+func (bcsi *bitmapContainerShortIterator) advanceIfNeeded(minval uint16) {
+	if bcsi.hasNext() && bcsi.peekNext() < minval {
+		bcsi.i = bcsi.ptr.NextSetBit(int(minval))
+	}
+}
 func newBitmapContainerShortIterator(a *bitmapContainer) *bitmapContainerShortIterator {
 	return &bitmapContainerShortIterator{a, a.NextSetBit(0)}
 }
-func (bc *bitmapContainer) getShortIterator() shortIterable {
+func (bc *bitmapContainer) getShortIterator() shortPeekable {
 	return newBitmapContainerShortIterator(bc)
 }
 
+type bitmapContainerManyIterator struct {
+	ptr    *bitmapContainer
+	base   int
+	bitset uint64
+}
+
+func (bcmi *bitmapContainerManyIterator) nextMany(hs uint32, buf []uint32) int {
+	n := 0
+	base := bcmi.base
+	bitset := bcmi.bitset
+
+	for n < len(buf) {
+		if bitset == 0 {
+			base++
+			if base >= len(bcmi.ptr.bitmap) {
+				bcmi.base = base
+				bcmi.bitset = bitset
+				return n
+			}
+			bitset = bcmi.ptr.bitmap[base]
+			continue
+		}
+		t := bitset & -bitset
+		buf[n] = uint32((base*64)+int(popcount(t-1))) | hs
+		n++
+		bitset ^= t
+	}
+
+	bcmi.base = base
+	bcmi.bitset = bitset
+	return n
+}
+
+func newBitmapContainerManyIterator(a *bitmapContainer) *bitmapContainerManyIterator {
+	return &bitmapContainerManyIterator{a, -1, 0}
+}
+
+func (bc *bitmapContainer) getManyIterator() manyIterable {
+	return newBitmapContainerManyIterator(bc)
+}
+
+type reverseBitmapContainerShortIterator struct {
+	ptr *bitmapContainer
+	i   int
+}
+
+func (bcsi *reverseBitmapContainerShortIterator) next() uint16 {
+	j := bcsi.i
+	bcsi.i = bcsi.ptr.PrevSetBit(bcsi.i - 1)
+	return uint16(j)
+}
+func (bcsi *reverseBitmapContainerShortIterator) hasNext() bool {
+	return bcsi.i >= 0
+}
+func newReverseBitmapContainerShortIterator(a *bitmapContainer) *reverseBitmapContainerShortIterator {
+	return &reverseBitmapContainerShortIterator{a, a.PrevSetBit(maxCapacity - 1)}
+}
+func (bc *bitmapContainer) getReverseIterator() shortIterable {
+	return newReverseBitmapContainerShortIterator(bc)
+}
+
+func (bc *bitmapContainer) minimum() uint16 {
+	for i := 0; i < len(bc.bitmap); i++ {
+		w := bc.bitmap[i]
+		if w != 0 {
+			return uint16(i*64 + numberOfTrailingZeros(w))
+		}
+	}
+	return MaxUint16
+}
+
+func (bc *bitmapContainer) maximum() uint16 {
+	for i := len(bc.bitmap) - 1; i >= 0; i-- {
+		w := bc.bitmap[i]
+		if w != 0 {
+			return uint16(i*64 + 63 - numberOfLeadingZeros(w))
+		}
+	}
+	return 0
+}
+
 func (bc *bitmapContainer) getSizeInBytes() int {
 	return len(bc.bitmap) * 8 // + bcBaseBytes
 }
 
 func (bc *bitmapContainer) serializedSizeInBytes() int {
-	return bc.Msgsize()
-	//return len(bc.bitmap) * 8 // +  bcBaseBytes
+	return len(bc.bitmap) * 8
 }
 
 const bcBaseBytes = int(unsafe.Sizeof(bitmapContainer{}))
@@ -387,6 +477,34 @@ func (bc *bitmapContainer) lazyORBitmap(value2 *bitmapContainer) container {
 	return answer.lazyIORBitmap(value2)
 }
 
+// lazyIXOR computes the symmetric difference in place, leaving the
+// cardinality invalid until repairAfterLazy is called
+func (bc *bitmapContainer) lazyIXOR(a container) container {
+	switch x := a.(type) {
+	case *arrayContainer:
+		for _, vc := range x.content {
+			bc.bitmap[uint(vc)>>6] ^= uint64(1) << (vc % 64)
+		}
+	case *bitmapContainer:
+		for k := 0; k < len(bc.bitmap); k++ {
+			bc.bitmap[k] ^= x.bitmap[k]
+		}
+	case *runContainer16:
+		for _, p := range x.iv {
+			flipBitmapRange(bc.bitmap, int(p.start), int(p.last)+1)
+		}
+	default:
+		panic("unsupported container type")
+	}
+	bc.cardinality = invalidCardinality
+	return bc
+}
+
+func (bc *bitmapContainer) lazyXOR(a container) container {
+	answer := bc.clone().(*bitmapContainer)
+	return answer.lazyIXOR(a)
+}
+
 func (bc *bitmapContainer) xor(a container) container {
 	switch x := a.(type) {
 	case *arrayContainer:
@@ -479,6 +597,24 @@ func (bc *bitmapContainer) intersects(a container) bool {
 	panic("unsupported container type")
 }
 
+func (bc *bitmapContainer) andCardinality(a container) int {
+	switch x := a.(type) {
+	case *arrayContainer:
+		answer := 0
+		for _, v := range x.content {
+			if bc.contains(v) {
+				answer++
+			}
+		}
+		return answer
+	case *bitmapContainer:
+		return int(popcntAndSlice(bc.bitmap, x.bitmap))
+	case *runContainer16:
+		return x.andCardinality(bc)
+	}
+	panic("unsupported container type")
+}
+
 func (bc *bitmapContainer) iand(a container) container {
 	switch x := a.(type) {
 	case *arrayContainer:
@@ -563,7 +699,9 @@ func (bc *bitmapContainer) andNot(a container) container {
 		return bc.andNotArray(x)
 	case *bitmapContainer:
 		return bc.andNotBitmap(x)
-	} // what happens if a is a run container?
+	case *runContainer16:
+		return bc.andNotRun16(x)
+	}
 	panic("unsupported container type")
 }
 
@@ -575,10 +713,28 @@ func (bc *bitmapContainer) iandNot(a container) container {
 		return bc.andNotArray(x)
 	case *bitmapContainer:
 		return bc.iandNotBitmap(x)
-	}// what happens if a is a run container?
+	case *runContainer16:
+		return bc.iandNotRun16(x)
+	}
 	panic("unsupported container type")
 }
 
+func (bc *bitmapContainer) andNotRun16(rc *runContainer16) container {
+	answer := bc.clone().(*bitmapContainer)
+	return answer.iandNotRun16(rc)
+}
+
+func (bc *bitmapContainer) iandNotRun16(rc *runContainer16) container {
+	for _, p := range rc.iv {
+		resetBitmapRange(bc.bitmap, int(p.start), int(p.last)+1)
+	}
+	bc.computeCardinality()
+	if bc.getCardinality() <= arrayDefaultMaxSize {
+		return bc.toArrayContainer()
+	}
+	return bc
+}
+
 func (bc *bitmapContainer) andNotArray(value2 *arrayContainer) container {
 	answer := bc.clone().(*bitmapContainer)
 	c := value2.getCardinality()
@@ -672,6 +828,25 @@ func (bc *bitmapContainer) fillArray(container []uint16) {
 	}
 }
 
+func (bc *bitmapContainer) getCardinalityInRange(start, end int) int {
+	return int(wordCardinalityForBitmapRange(bc.bitmap, start, end))
+}
+
+func (bc *bitmapContainer) containsRange(start, end int) bool {
+	if start >= end {
+		return true
+	}
+	return bc.getCardinalityInRange(start, end) == end-start
+}
+
+func (bc *bitmapContainer) intersectsRange(start, end int) bool {
+	if start >= end {
+		return false
+	}
+	i := bc.NextSetBit(start)
+	return i >= 0 && i < end
+}
+
 func (bc *bitmapContainer) NextSetBit(i int) int {
 	x := i / 64
 	if x >= len(bc.bitmap) {
@@ -691,6 +866,82 @@ func (bc *bitmapContainer) NextSetBit(i int) int {
 	return -1
 }
 
+// PrevSetBit returns the largest set bit that is at most i, or -1 if there is none
+func (bc *bitmapContainer) PrevSetBit(i int) int {
+	if i < 0 {
+		return -1
+	}
+	x := i / 64
+	if x >= len(bc.bitmap) {
+		return -1
+	}
+	w := bc.bitmap[x]
+	w = w << uint(63-i%64)
+	if w != 0 {
+		return i - numberOfLeadingZeros(w)
+	}
+	x--
+	for ; x >= 0; x-- {
+		if bc.bitmap[x] != 0 {
+			return (x * 64) + 63 - numberOfLeadingZeros(bc.bitmap[x])
+		}
+	}
+	return -1
+}
+
+func (bc *bitmapContainer) addOffset(x uint16) (container, container) {
+	lo := newBitmapContainer()
+	hi := newBitmapContainer()
+	put := func(i int, w uint64) {
+		if i < len(lo.bitmap) {
+			lo.bitmap[i] |= w
+		} else if i-len(lo.bitmap) < len(hi.bitmap) {
+			hi.bitmap[i-len(lo.bitmap)] |= w
+		}
+	}
+	b, s := int(x)/64, uint(x)%64
+	for i, w := range bc.bitmap {
+		put(i+b, w<<s)
+		if s != 0 {
+			put(i+b+1, w>>(64-s))
+		}
+	}
+	return shiftedBitmap(lo), shiftedBitmap(hi)
+}
+
+// shiftedBitmap returns the part of a shifted bitmap container with the
+// appropriate type, nil if it is empty
+func shiftedBitmap(bc *bitmapContainer) container {
+	bc.computeCardinality()
+	if bc.cardinality == 0 {
+		return nil
+	}
+	if bc.cardinality <= arrayDefaultMaxSize {
+		return bc.toArrayContainer()
+	}
+	return bc
+}
+
+// nextClearBit returns the smallest bit that is at least i and not set,
+// or the capacity of the container if there is none
+func (bc *bitmapContainer) nextClearBit(i int) int {
+	x := i / 64
+	if x >= len(bc.bitmap) {
+		return len(bc.bitmap) * 64
+	}
+	w := ^bc.bitmap[x] >> uint(i%64)
+	if w != 0 {
+		return i + numberOfTrailingZeros(w)
+	}
+	x++
+	for ; x < len(bc.bitmap); x++ {
+		if bc.bitmap[x] != ^uint64(0) {
+			return (x * 64) + numberOfTrailingZeros(^bc.bitmap[x])
+		}
+	}
+	return len(bc.bitmap) * 64
+}
+
 // reference the java implementation
 // https://github.com/RoaringBitmap/RoaringBitmap/blob/master/src/main/java/org/roaringbitmap/BitmapContainer.java#L875-L892
 //
@@ -717,6 +968,21 @@ func (bc *bitmapContainer) numberOfRuns() int {
 	return int(numRuns)
 }
 
+// validate checks that the cached cardinality matches the bits and is above the array threshold
+func (bc *bitmapContainer) validate() error {
+	if len(bc.bitmap) != maxCapacity/64 {
+		return fmt.Errorf("bitmap container has %d words instead of %d", len(bc.bitmap), maxCapacity/64)
+	}
+	card := int(popcntSlice(bc.bitmap))
+	if bc.cardinality != card {
+		return fmt.Errorf("bitmap container has a cardinality of %d but %d bits are set", bc.cardinality, card)
+	}
+	if card <= arrayDefaultMaxSize {
+		return fmt.Errorf("bitmap container has %d values, it should be an array container", card)
+	}
+	return nil
+}
+
 // convert to run or array *if needed*
 func (bc *bitmapContainer) toEfficientContainer() container {
 
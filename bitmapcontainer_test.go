@@ -6,6 +6,28 @@ import (
 	"testing"
 )
 
+func TestBitmapContainerAndNotRun060(t *testing.T) {
+	Convey("bitmapContainer andNot and iandNot with a run container clear the runs", t, func() {
+		rc := newRunContainer16TakeOwnership([]interval16{{start: 0, last: 99}, {start: 5000, last: 65535}})
+		bc := newBitmapContainerwithRange(0, 9999)
+
+		answer := bc.andNot(rc)
+		So(answer.getCardinality(), ShouldEqual, 4900)
+		So(answer.contains(100), ShouldBeTrue)
+		So(answer.contains(4999), ShouldBeTrue)
+		So(answer.contains(99), ShouldBeFalse)
+		So(answer.contains(5000), ShouldBeFalse)
+		So(bc.getCardinality(), ShouldEqual, 10000)
+
+		// small results become array containers
+		rc = newRunContainer16Range(10, 65535)
+		answer = bc.iandNot(rc)
+		_, isArray := answer.(*arrayContainer)
+		So(isArray, ShouldBeTrue)
+		So(answer.getCardinality(), ShouldEqual, 10)
+	})
+}
+
 func TestBitmapContainerNumberOfRuns024(t *testing.T) {
 
 	Convey("bitmapContainer's numberOfRuns() function should be correct against the runContainer equivalent",
@@ -65,3 +87,22 @@ func TestBitmapContainerNumberOfRuns024(t *testing.T) {
 
 		})
 }
+
+func TestBitmapContainerPrevSetBit(t *testing.T) {
+	Convey("PrevSetBit should mirror NextSetBit", t, func() {
+		bc := newBitmapContainer()
+		So(bc.PrevSetBit(maxCapacity-1), ShouldEqual, -1)
+		for _, v := range []uint16{0, 63, 64, 1000, 65535} {
+			bc.iadd(v)
+		}
+		So(bc.PrevSetBit(maxCapacity-1), ShouldEqual, 65535)
+		So(bc.PrevSetBit(65534), ShouldEqual, 1000)
+		So(bc.PrevSetBit(1000), ShouldEqual, 1000)
+		So(bc.PrevSetBit(999), ShouldEqual, 64)
+		So(bc.PrevSetBit(63), ShouldEqual, 63)
+		So(bc.PrevSetBit(62), ShouldEqual, 0)
+		So(bc.PrevSetBit(-1), ShouldEqual, -1)
+		So(bc.minimum(), ShouldEqual, 0)
+		So(bc.maximum(), ShouldEqual, 65535)
+	})
+}
@@ -0,0 +1,332 @@
+package roaring
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Operation identifies the comparison done by BSI.CompareValue
+type Operation int
+
+const (
+	// EQ selects the columns whose value is equal to the given value
+	EQ Operation = iota
+	// LT selects the columns whose value is less than the given value
+	LT
+	// LE selects the columns whose value is less than or equal to the given value
+	LE
+	// GT selects the columns whose value is greater than the given value
+	GT
+	// GE selects the columns whose value is greater than or equal to the given value
+	GE
+	// RANGE selects the columns whose value is in the given range, bounds included
+	RANGE
+)
+
+// BSI is a bit-sliced index: it stores an integer value for each column ID
+// of a set. Bit i of the values is stored in its own Bitmap, so that range
+// predicates and aggregates are computed with bitmap operations instead of
+// scanning the values. Values are stored as offsets from minValue.
+type BSI struct {
+	bA       []*Bitmap // bA[i] holds the columns whose value has bit i set
+	eBM      *Bitmap   // existence bitmap, the columns that have a value
+	minValue int64
+}
+
+// NewBSI creates an empty BSI for values in [minValue, maxValue].
+// The index grows as needed if larger values are set; values smaller
+// than minValue cannot be stored.
+func NewBSI(maxValue, minValue int64) *BSI {
+	if maxValue < minValue {
+		panic("NewBSI called with maxValue < minValue")
+	}
+	b := &BSI{eBM: NewBitmap(), minValue: minValue}
+	b.grow(uint64(maxValue - minValue))
+	return b
+}
+
+// grow adds bit slices until the offset v can be represented
+func (b *BSI) grow(v uint64) {
+	for len(b.bA) < 64 && v>>uint(len(b.bA)) != 0 {
+		b.bA = append(b.bA, NewBitmap())
+	}
+}
+
+// BitCount returns the number of bit slices of the index
+func (b *BSI) BitCount() int {
+	return len(b.bA)
+}
+
+// GetCardinality returns the number of columns that have a value
+func (b *BSI) GetCardinality() uint64 {
+	return b.eBM.GetCardinality()
+}
+
+// GetExistenceBitmap returns the columns that have a value, the result must not be modified
+func (b *BSI) GetExistenceBitmap() *Bitmap {
+	return b.eBM
+}
+
+// SetValue sets the value of a column, it panics if value is smaller
+// than the minValue the index was created with
+func (b *BSI) SetValue(columnID uint32, value int64) {
+	if value < b.minValue {
+		panic(fmt.Sprintf("BSI.SetValue: value %d is smaller than the minimum %d", value, b.minValue))
+	}
+	v := uint64(value - b.minValue)
+	b.grow(v)
+	for i, bm := range b.bA {
+		if v&(1<<uint(i)) != 0 {
+			bm.Add(columnID)
+		} else {
+			bm.Remove(columnID)
+		}
+	}
+	b.eBM.Add(columnID)
+}
+
+// GetValue returns the value of a column, and false if the column has no value
+func (b *BSI) GetValue(columnID uint32) (int64, bool) {
+	if !b.eBM.Contains(columnID) {
+		return 0, false
+	}
+	v := uint64(0)
+	for i, bm := range b.bA {
+		if bm.Contains(columnID) {
+			v |= 1 << uint(i)
+		}
+	}
+	return int64(v) + b.minValue, true
+}
+
+// ClearValue removes the value of a column
+func (b *BSI) ClearValue(columnID uint32) {
+	for _, bm := range b.bA {
+		bm.Remove(columnID)
+	}
+	b.eBM.Remove(columnID)
+}
+
+// foundSetOrAll restricts foundSet to the columns having a value,
+// a nil foundSet stands for all of them. The result is a new bitmap.
+func (b *BSI) foundSetOrAll(foundSet *Bitmap) *Bitmap {
+	if foundSet == nil {
+		return b.eBM.Clone()
+	}
+	return And(b.eBM, foundSet)
+}
+
+// compare returns the columns of candidates whose value is respectively less
+// than, equal to and greater than value
+func (b *BSI) compare(value int64, candidates *Bitmap) (lt, eq, gt *Bitmap) {
+	if value < b.minValue {
+		return NewBitmap(), NewBitmap(), candidates
+	}
+	v := uint64(value - b.minValue)
+	if len(b.bA) < 64 && v>>uint(len(b.bA)) != 0 {
+		return candidates, NewBitmap(), NewBitmap()
+	}
+	lt, eq, gt = NewBitmap(), candidates, NewBitmap()
+	for i := len(b.bA) - 1; i >= 0; i-- {
+		if v&(1<<uint(i)) != 0 {
+			lt.Or(AndNot(eq, b.bA[i]))
+			eq = And(eq, b.bA[i])
+		} else {
+			gt.Or(And(eq, b.bA[i]))
+			eq = AndNot(eq, b.bA[i])
+		}
+	}
+	return lt, eq, gt
+}
+
+// CompareValue returns the columns of foundSet (or of the whole index if
+// foundSet is nil) whose value satisfies op with respect to value. For
+// RANGE, the columns whose value is in [value, end] are returned; end is
+// ignored by the other operations.
+func (b *BSI) CompareValue(op Operation, value, end int64, foundSet *Bitmap) *Bitmap {
+	candidates := b.foundSetOrAll(foundSet)
+	switch op {
+	case EQ:
+		_, eq, _ := b.compare(value, candidates)
+		return eq
+	case LT:
+		lt, _, _ := b.compare(value, candidates)
+		return lt
+	case LE:
+		lt, eq, _ := b.compare(value, candidates)
+		lt.Or(eq)
+		return lt
+	case GT:
+		_, _, gt := b.compare(value, candidates)
+		return gt
+	case GE:
+		_, eq, gt := b.compare(value, candidates)
+		gt.Or(eq)
+		return gt
+	case RANGE:
+		if end < value {
+			return NewBitmap()
+		}
+		_, eq, gt := b.compare(value, candidates)
+		gt.Or(eq)
+		lt, eq, _ := b.compare(end, gt)
+		lt.Or(eq)
+		return lt
+	}
+	panic("BSI.CompareValue: unknown operation")
+}
+
+// Sum returns the sum of the values of the columns of foundSet (or of the
+// whole index if foundSet is nil), and the number of columns summed
+func (b *BSI) Sum(foundSet *Bitmap) (sum int64, count uint64) {
+	candidates := b.foundSetOrAll(foundSet)
+	count = candidates.GetCardinality()
+	for i, bm := range b.bA {
+		sum += int64(bm.AndCardinality(candidates) << uint(i))
+	}
+	return sum + int64(count)*b.minValue, count
+}
+
+// MinMax returns the smallest and largest values of the columns of foundSet
+// (or of the whole index if foundSet is nil), ok is false if there is no such column
+func (b *BSI) MinMax(foundSet *Bitmap) (min, max int64, ok bool) {
+	candidates := b.foundSetOrAll(foundSet)
+	if candidates.IsEmpty() {
+		return 0, 0, false
+	}
+	minCandidates, maxCandidates := candidates, candidates
+	var vmin, vmax uint64
+	for i := len(b.bA) - 1; i >= 0; i-- {
+		if x := AndNot(minCandidates, b.bA[i]); !x.IsEmpty() {
+			minCandidates = x
+		} else {
+			vmin |= 1 << uint(i)
+		}
+		if x := And(maxCandidates, b.bA[i]); !x.IsEmpty() {
+			maxCandidates = x
+			vmax |= 1 << uint(i)
+		}
+	}
+	return int64(vmin) + b.minValue, int64(vmax) + b.minValue, true
+}
+
+// TopK returns the k columns of foundSet (or of the whole index if foundSet
+// is nil) having the largest values. Ties are broken in favor of the
+// smallest column IDs. Fewer than k columns are returned if there are not
+// enough candidates.
+func (b *BSI) TopK(k uint64, foundSet *Bitmap) *Bitmap {
+	candidates := b.foundSetOrAll(foundSet)
+	if candidates.GetCardinality() <= k {
+		return candidates
+	}
+	// g holds columns known to be in the answer, e the columns whose
+	// value is equal, on the bits seen so far, to the k-th largest
+	g := NewBitmap()
+	e := candidates
+	for i := len(b.bA) - 1; i >= 0; i-- {
+		x := Or(g, And(e, b.bA[i]))
+		card := x.GetCardinality()
+		if card > k {
+			e = And(e, b.bA[i])
+		} else if card < k {
+			g = x
+			e = AndNot(e, b.bA[i])
+		} else {
+			return x
+		}
+	}
+	// the columns of e all have the same value, complete with the smallest ones
+	need := k - g.GetCardinality()
+	for it := e.Iterator(); need > 0 && it.HasNext(); need-- {
+		g.Add(it.Next())
+	}
+	return g
+}
+
+// Clone creates a copy of the BSI
+func (b *BSI) Clone() *BSI {
+	c := &BSI{eBM: b.eBM.Clone(), minValue: b.minValue, bA: make([]*Bitmap, len(b.bA))}
+	for i, bm := range b.bA {
+		c.bA[i] = bm.Clone()
+	}
+	return c
+}
+
+// WriteTo writes a serialized version of the BSI to stream: the minimum value
+// as a little-endian int64, the number of bit slices as a little-endian uint32,
+// then the existence bitmap and each bit slice in the standard roaring format.
+func (b *BSI) WriteTo(stream io.Writer) (int64, error) {
+	var buf [12]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(b.minValue))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(len(b.bA)))
+	n, err := stream.Write(buf[:])
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+	for _, bm := range append([]*Bitmap{b.eBM}, b.bA...) {
+		nb, err := bm.WriteTo(stream)
+		written += nb
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// ReadFrom reads a serialized version of the BSI from stream, in the format
+// produced by WriteTo. The previous content of the BSI is discarded.
+// It returns ErrInvalidFormat if a bit slice has columns that are not in the
+// existence bitmap.
+func (b *BSI) ReadFrom(stream io.Reader) (int64, error) {
+	var buf [12]byte
+	n, err := io.ReadFull(stream, buf[:])
+	read := int64(n)
+	if err != nil {
+		return read, readError(err)
+	}
+	nslices := binary.LittleEndian.Uint32(buf[8:])
+	if nslices > 64 {
+		return read, ErrInvalidFormat
+	}
+	eBM := NewBitmap()
+	nb, err := eBM.ReadFrom(stream)
+	read += nb
+	if err != nil {
+		return read, err
+	}
+	bA := make([]*Bitmap, nslices)
+	for i := range bA {
+		bA[i] = NewBitmap()
+		nb, err = bA[i].ReadFrom(stream)
+		read += nb
+		if err != nil {
+			return read, err
+		}
+		if bA[i].AndNotCardinality(eBM) != 0 {
+			return read, ErrInvalidFormat
+		}
+	}
+	b.minValue = int64(binary.LittleEndian.Uint64(buf[:]))
+	b.eBM = eBM
+	b.bA = bA
+	return read, nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface for the BSI
+func (b *BSI) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := b.WriteTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface for the BSI
+func (b *BSI) UnmarshalBinary(data []byte) error {
+	_, err := b.ReadFrom(bytes.NewReader(data))
+	return err
+}
@@ -0,0 +1,224 @@
+package roaring
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func randomBSI(r *rand.Rand, n int, minValue, maxValue int64) (*BSI, map[uint32]int64) {
+	b := NewBSI(maxValue, minValue)
+	m := make(map[uint32]int64)
+	for i := 0; i < n; i++ {
+		col := uint32(r.Intn(3 * n))
+		v := minValue + r.Int63n(maxValue-minValue+1)
+		b.SetValue(col, v)
+		m[col] = v
+	}
+	return b, m
+}
+
+func bitmapOfColumns(m map[uint32]int64, keep func(uint32, int64) bool) *Bitmap {
+	rb := NewBitmap()
+	for col, v := range m {
+		if keep(col, v) {
+			rb.Add(col)
+		}
+	}
+	return rb
+}
+
+func TestBSISetGet(t *testing.T) {
+	Convey("BSI SetValue, GetValue and ClearValue", t, func() {
+		b := NewBSI(100, 0)
+		So(b.BitCount(), ShouldEqual, 7)
+		b.SetValue(1, 42)
+		b.SetValue(2, 0)
+		b.SetValue(1<<20, 100)
+		b.SetValue(1, 7) // overwrite
+		v, ok := b.GetValue(1)
+		So(ok, ShouldBeTrue)
+		So(v, ShouldEqual, 7)
+		v, ok = b.GetValue(2)
+		So(ok, ShouldBeTrue)
+		So(v, ShouldEqual, 0)
+		_, ok = b.GetValue(3)
+		So(ok, ShouldBeFalse)
+		So(b.GetCardinality(), ShouldEqual, 3)
+
+		b.SetValue(5, 1<<40) // grows
+		So(b.BitCount(), ShouldEqual, 41)
+		v, _ = b.GetValue(5)
+		So(v, ShouldEqual, 1<<40)
+
+		b.ClearValue(1)
+		_, ok = b.GetValue(1)
+		So(ok, ShouldBeFalse)
+		So(b.GetExistenceBitmap().ToArray(), ShouldResemble, []uint32{2, 5, 1 << 20})
+
+		n := NewBSI(10, -10)
+		n.SetValue(0, -10)
+		n.SetValue(1, -3)
+		v, _ = n.GetValue(1)
+		So(v, ShouldEqual, -3)
+		So(func() { n.SetValue(2, -11) }, ShouldPanic)
+
+		e := NewBSI(9223372036854775807, -9223372036854775808)
+		e.SetValue(0, -9223372036854775808)
+		e.SetValue(1, 9223372036854775807)
+		v, _ = e.GetValue(0)
+		So(v, ShouldEqual, -9223372036854775808)
+		v, _ = e.GetValue(1)
+		So(v, ShouldEqual, 9223372036854775807)
+	})
+}
+
+func TestBSICompareValue(t *testing.T) {
+	Convey("BSI CompareValue should agree with a map", t, func() {
+		r := rand.New(rand.NewSource(10))
+		b, m := randomBSI(r, 3000, -50, 1000)
+		foundSet := NewBitmap()
+		for i := 0; i < 3000; i++ {
+			foundSet.Add(uint32(r.Intn(9000)))
+		}
+		for _, value := range []int64{-100, -50, -1, 0, 1, 17, 500, 999, 1000, 5000} {
+			end := value + 300
+			all := func(col uint32, v int64) bool { return true }
+			for _, fs := range []*Bitmap{nil, foundSet} {
+				inFS := all
+				if fs != nil {
+					inFS = func(col uint32, v int64) bool { return foundSet.Contains(col) }
+				}
+				check := func(op Operation, keep func(int64) bool) {
+					expected := bitmapOfColumns(m, func(col uint32, v int64) bool { return inFS(col, v) && keep(v) })
+					So(b.CompareValue(op, value, end, fs).Equals(expected), ShouldBeTrue)
+				}
+				check(EQ, func(v int64) bool { return v == value })
+				check(LT, func(v int64) bool { return v < value })
+				check(LE, func(v int64) bool { return v <= value })
+				check(GT, func(v int64) bool { return v > value })
+				check(GE, func(v int64) bool { return v >= value })
+				check(RANGE, func(v int64) bool { return v >= value && v <= end })
+			}
+		}
+		So(b.CompareValue(RANGE, 10, 5, nil).IsEmpty(), ShouldBeTrue)
+	})
+}
+
+func TestBSIAggregates(t *testing.T) {
+	Convey("BSI Sum, MinMax and TopK should agree with a map", t, func() {
+		r := rand.New(rand.NewSource(11))
+		b, m := randomBSI(r, 2000, -1000, 100000)
+		foundSet := NewBitmap()
+		for i := 0; i < 2000; i++ {
+			foundSet.Add(uint32(r.Intn(6000)))
+		}
+		for _, fs := range []*Bitmap{nil, foundSet} {
+			var sum int64
+			var count uint64
+			first := true
+			var min, max int64
+			var cols []uint32
+			for col, v := range m {
+				if fs != nil && !fs.Contains(col) {
+					continue
+				}
+				sum += v
+				count++
+				cols = append(cols, col)
+				if first || v < min {
+					min = v
+				}
+				if first || v > max {
+					max = v
+				}
+				first = false
+			}
+			s, c := b.Sum(fs)
+			So(s, ShouldEqual, sum)
+			So(c, ShouldEqual, count)
+			gotMin, gotMax, ok := b.MinMax(fs)
+			So(ok, ShouldBeTrue)
+			So(gotMin, ShouldEqual, min)
+			So(gotMax, ShouldEqual, max)
+
+			sort.Slice(cols, func(i, j int) bool {
+				if m[cols[i]] != m[cols[j]] {
+					return m[cols[i]] > m[cols[j]]
+				}
+				return cols[i] < cols[j]
+			})
+			for _, k := range []int{0, 1, 10, 100, len(cols), len(cols) + 10} {
+				n := k
+				if n > len(cols) {
+					n = len(cols)
+				}
+				So(b.TopK(uint64(k), fs).Equals(BitmapOf(cols[:n]...)), ShouldBeTrue)
+			}
+		}
+		_, _, ok := b.MinMax(NewBitmap())
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("BSI TopK should break ties with the smallest columns", t, func() {
+		b := NewBSI(10, 0)
+		for col := uint32(0); col < 10; col++ {
+			b.SetValue(col, 5)
+		}
+		b.SetValue(20, 9)
+		So(b.TopK(4, nil).ToArray(), ShouldResemble, []uint32{0, 1, 2, 20})
+	})
+}
+
+func TestBSISerialization(t *testing.T) {
+	Convey("BSI serialization round trip", t, func() {
+		r := rand.New(rand.NewSource(12))
+		b, m := randomBSI(r, 1000, -7, 1<<20)
+		var buf bytes.Buffer
+		n, err := b.WriteTo(&buf)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, buf.Len())
+		data := append([]byte(nil), buf.Bytes()...)
+
+		other := NewBSI(0, 0)
+		nr, err := other.ReadFrom(&buf)
+		So(err, ShouldBeNil)
+		So(nr, ShouldEqual, n)
+		So(other.BitCount(), ShouldEqual, b.BitCount())
+		for col, v := range m {
+			got, ok := other.GetValue(col)
+			So(ok, ShouldBeTrue)
+			So(got, ShouldEqual, v)
+		}
+		So(other.GetCardinality(), ShouldEqual, len(m))
+
+		// every slice uses the standard format
+		existence := NewBitmap()
+		_, err = existence.ReadFrom(bytes.NewReader(data[12:]))
+		So(err, ShouldBeNil)
+		So(existence.Equals(b.GetExistenceBitmap()), ShouldBeTrue)
+
+		marshaled, err := b.Clone().MarshalBinary()
+		So(err, ShouldBeNil)
+		So(marshaled, ShouldResemble, data)
+		So(NewBSI(0, 0).UnmarshalBinary(data[:len(data)-1]), ShouldEqual, ErrTruncated)
+	})
+
+	Convey("BSI ReadFrom should reject bit slices with columns missing from the existence bitmap", t, func() {
+		var buf bytes.Buffer
+		buf.Write(make([]byte, 8))
+		binary.Write(&buf, binary.LittleEndian, uint32(2))
+		for _, bm := range []*Bitmap{BitmapOf(1, 5), BitmapOf(5), BitmapOf(1, 7)} {
+			_, err := bm.WriteTo(&buf)
+			So(err, ShouldBeNil)
+		}
+		b := NewBSI(0, 0)
+		_, err := b.ReadFrom(&buf)
+		So(err, ShouldEqual, ErrInvalidFormat)
+		So(b.GetCardinality(), ShouldEqual, 0)
+	})
+}
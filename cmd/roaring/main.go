@@ -0,0 +1,322 @@
+// Command roaring inspects and manipulates serialized roaring bitmaps.
+//
+// Bitmaps are read and written in the portable format by default, which is
+// shared with the Java and C implementations; the msgpack/snappy format of
+// this package and the base64 of the portable format are also supported.
+// A file name of "-" stands for the standard input.
+//
+// Usage:
+//
+//	roaring stats [-format f] file...
+//	roaring dump [-format f] [-ranges] file
+//	roaring validate [-format f] file...
+//	roaring convert -from f -to f [-o out] file
+//	roaring and|or|xor|andnot [-format f] [-o out] file...
+//	roaring runoptimize [-format f] [-o out] file
+//
+// The formats are portable, msgpack and base64. The results of convert,
+// of the set operations and of runoptimize are written to the standard
+// output unless -o is given; andnot removes from the first bitmap all the
+// following ones.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+const usage = `usage: roaring <command> [flags] file...
+
+commands:
+  stats        print the statistics and the containers of each bitmap
+  dump         print the values of a bitmap, or its ranges with -ranges
+  validate     check that each file holds a valid bitmap
+  convert      convert a bitmap between the portable, msgpack and base64 formats
+  and, or, xor, andnot
+               combine several bitmaps
+  runoptimize  compress the runs of a bitmap
+
+Run 'roaring <command> -h' for the flags of a command.
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "roaring:", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command line args, reading "-" from stdin and writing to stdout
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(strings.TrimSpace(usage))
+	}
+	c := &command{name: args[0], stdin: stdin, stdout: stdout}
+	c.flags = flag.NewFlagSet(c.name, flag.ContinueOnError)
+	c.flags.SetOutput(ioutil.Discard)
+	switch c.name {
+	case "stats":
+		return c.run(args[1:], 1, -1, c.stats)
+	case "dump":
+		ranges := c.flags.Bool("ranges", false, "print the ranges of consecutive values instead of the values")
+		return c.run(args[1:], 1, 1, func(files []string) error {
+			return c.dump(files[0], *ranges)
+		})
+	case "validate":
+		return c.run(args[1:], 1, -1, c.validate)
+	case "convert":
+		from := c.flags.String("from", "portable", "input `format`: portable, msgpack or base64")
+		to := c.flags.String("to", "portable", "output `format`: portable, msgpack or base64")
+		out := c.flags.String("o", "", "output `file`, the standard output by default")
+		return c.run(args[1:], 1, 1, func(files []string) error {
+			rb, err := c.readFile(files[0], *from)
+			if err != nil {
+				return err
+			}
+			return c.write(rb, *out, *to)
+		})
+	case "and", "or", "xor", "andnot":
+		out := c.flags.String("o", "", "output `file`, the standard output by default")
+		return c.run(args[1:], 1, -1, func(files []string) error {
+			return c.aggregate(files, *out)
+		})
+	case "runoptimize":
+		out := c.flags.String("o", "", "output `file`, the standard output by default")
+		return c.run(args[1:], 1, 1, func(files []string) error {
+			rb, err := c.read(files[0])
+			if err != nil {
+				return err
+			}
+			rb.RunOptimize()
+			return c.write(rb, *out, *c.format)
+		})
+	case "help", "-h", "-help", "--help":
+		_, err := io.WriteString(stdout, usage)
+		return err
+	}
+	return fmt.Errorf("unknown command %q\n%s", c.name, strings.TrimSpace(usage))
+}
+
+// command holds the state of one subcommand
+type command struct {
+	name   string
+	flags  *flag.FlagSet
+	format *string
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+// run parses the flags and calls f with the files, checking that there are
+// at least min of them and at most max when max is not negative
+func (c *command) run(args []string, min, max int, f func(files []string) error) error {
+	if c.format == nil && c.flags.Lookup("from") == nil {
+		c.format = c.flags.String("format", "portable", "input `format`: portable, msgpack or base64")
+	}
+	if err := c.flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return c.help()
+		}
+		return fmt.Errorf("%s: %v", c.name, err)
+	}
+	files := c.flags.Args()
+	if len(files) < min || max >= 0 && len(files) > max {
+		return fmt.Errorf("%s: wrong number of files\n%s", c.name, c.usage())
+	}
+	return f(files)
+}
+
+func (c *command) usage() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "usage: roaring %s [flags] file...\n", c.name)
+	c.flags.SetOutput(&buf)
+	c.flags.PrintDefaults()
+	c.flags.SetOutput(ioutil.Discard)
+	return strings.TrimSpace(buf.String())
+}
+
+func (c *command) help() error {
+	_, err := fmt.Fprintln(c.stdout, c.usage())
+	return err
+}
+
+// open returns the content of file, "-" being the standard input
+func (c *command) open(file string) ([]byte, error) {
+	if file == "-" {
+		return ioutil.ReadAll(c.stdin)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// read reads a bitmap in the format given by the -format flag
+func (c *command) read(file string) (*roaring.Bitmap, error) {
+	return c.readFile(file, *c.format)
+}
+
+// readFile reads a bitmap in the given format
+func (c *command) readFile(file, format string) (*roaring.Bitmap, error) {
+	data, err := c.open(file)
+	if err != nil {
+		return nil, err
+	}
+	rb := roaring.NewBitmap()
+	switch format {
+	case "portable":
+		var n int64
+		n, err = rb.ReadFrom(bytes.NewReader(data))
+		if err == nil && n != int64(len(data)) {
+			err = fmt.Errorf("%d unexpected bytes after the bitmap", int64(len(data))-n)
+		}
+	case "msgpack":
+		_, err = rb.ReadFromMsgpack(bytes.NewReader(data))
+	case "base64":
+		_, err = rb.FromBase64(strings.TrimSpace(string(data)))
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return rb, nil
+}
+
+// write writes rb in the given format to the file out, or to the standard output if out is empty
+func (c *command) write(rb *roaring.Bitmap, out, format string) error {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "portable":
+		_, err = rb.WriteTo(&buf)
+	case "msgpack":
+		_, err = rb.WriteToMsgpack(&buf)
+	case "base64":
+		var s string
+		s, err = rb.ToBase64()
+		buf.WriteString(s + "\n")
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = c.stdout.Write(buf.Bytes())
+		return err
+	}
+	return ioutil.WriteFile(out, buf.Bytes(), 0644)
+}
+
+func (c *command) stats(files []string) error {
+	for i, file := range files {
+		rb, err := c.read(file)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(c.stdout)
+		}
+		if len(files) > 1 {
+			fmt.Fprintf(c.stdout, "%s:\n", file)
+		}
+		s := rb.Stats()
+		w := tabwriter.NewWriter(c.stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "cardinality:\t%d\t\n", s.Cardinality)
+		fmt.Fprintf(w, "containers:\t%d\t\n", s.Containers)
+		fmt.Fprintf(w, "serialized size:\t%d\tbytes\n", rb.GetSerializedSizeInBytes())
+		fmt.Fprintf(w, "size in memory:\t%d\tbytes\n", rb.GetSizeInBytes())
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "type\tcontainers\tvalues\tbytes\t")
+		fmt.Fprintf(w, "array\t%d\t%d\t%d\t\n", s.ArrayContainers, s.ArrayContainerValues, s.ArrayContainerBytes)
+		fmt.Fprintf(w, "bitmap\t%d\t%d\t%d\t\n", s.BitmapContainers, s.BitmapContainerValues, s.BitmapContainerBytes)
+		fmt.Fprintf(w, "run\t%d\t%d\t%d\t\n", s.RunContainers, s.RunContainerValues, s.RunContainerBytes)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "key\ttype\tcardinality\truns\tbytes\t")
+		for _, cs := range rb.ContainerStats() {
+			fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%d\t\n", cs.Key, cs.Type, cs.Cardinality, cs.Runs, cs.Bytes)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *command) dump(file string, ranges bool) error {
+	rb, err := c.read(file)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(c.stdout)
+	if ranges {
+		for _, r := range rb.Ranges() {
+			if r.End-r.Start == 1 {
+				fmt.Fprintf(w, "%d\n", r.Start)
+			} else {
+				fmt.Fprintf(w, "%d-%d\n", r.Start, r.End-1)
+			}
+		}
+	} else {
+		for it := rb.Iterator(); it.HasNext(); {
+			fmt.Fprintf(w, "%d\n", it.Next())
+		}
+	}
+	return w.Flush()
+}
+
+func (c *command) validate(files []string) error {
+	failed := 0
+	for _, file := range files {
+		rb, err := c.read(file)
+		if err == nil {
+			err = rb.Validate()
+			if err != nil {
+				err = fmt.Errorf("%s: %v", file, err)
+			}
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintln(c.stdout, err)
+			continue
+		}
+		fmt.Fprintf(c.stdout, "%s: ok, %d values\n", file, rb.GetCardinality())
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files are invalid", failed, len(files))
+	}
+	return nil
+}
+
+func (c *command) aggregate(files []string, out string) error {
+	bitmaps := make([]*roaring.Bitmap, len(files))
+	for i, file := range files {
+		rb, err := c.read(file)
+		if err != nil {
+			return err
+		}
+		bitmaps[i] = rb
+	}
+	var answer *roaring.Bitmap
+	switch c.name {
+	case "and":
+		answer = roaring.FastAnd(bitmaps...)
+	case "or":
+		answer = roaring.FastOr(bitmaps...)
+	case "xor":
+		answer = roaring.FastXor(bitmaps...)
+	case "andnot":
+		answer = roaring.FastAndNot(bitmaps[0], bitmaps[1:]...)
+	}
+	if len(bitmaps) == 1 {
+		answer = bitmaps[0]
+	}
+	return c.write(answer, out, *c.format)
+}
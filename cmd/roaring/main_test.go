@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+const withRuns = "../../testdata/bitmapwithruns.bin"
+const withoutRuns = "../../testdata/bitmapwithoutruns.bin"
+
+// runOutput runs the command line args and returns its standard output
+func runOutput(stdin []byte, args ...string) (string, error) {
+	var out bytes.Buffer
+	err := run(args, bytes.NewReader(stdin), &out)
+	return out.String(), err
+}
+
+func readBitmap(t *testing.T, file string) *roaring.Bitmap {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb := roaring.NewBitmap()
+	if err := rb.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	return rb
+}
+
+func TestStatsDumpValidate(t *testing.T) {
+	rb := readBitmap(t, withRuns)
+	out, err := runOutput(nil, "stats", withRuns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := rb.Stats()
+	for _, want := range []string{"cardinality:", "run", "key"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("stats output does not contain %q:\n%s", want, out)
+		}
+	}
+	if !strings.Contains(out, "serialized size:") || strings.Count(out, "\n") < int(s.Containers) {
+		t.Errorf("stats output does not list the containers:\n%s", out)
+	}
+
+	out, err = runOutput(nil, "dump", withRuns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(out, "\n"); uint64(lines) != rb.GetCardinality() {
+		t.Errorf("dump printed %d values, expected %d", lines, rb.GetCardinality())
+	}
+	out, err = runOutput(nil, "dump", "-ranges", withRuns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(out, "\n"); lines != len(rb.Ranges()) {
+		t.Errorf("dump -ranges printed %d ranges, expected %d", lines, len(rb.Ranges()))
+	}
+	out, err = runOutput(nil, "dump", "-ranges", "-format", "base64", "-")
+	if err == nil {
+		t.Errorf("dump of an empty input should fail, got %q", out)
+	}
+
+	out, err = runOutput(nil, "validate", withRuns, withoutRuns)
+	if err != nil || strings.Count(out, ": ok") != 2 {
+		t.Errorf("validate failed: %v\n%s", err, out)
+	}
+	malformed, _ := filepath.Glob("../../testdata/malformed/invalid_*.bin")
+	truncated, _ := filepath.Glob("../../testdata/malformed/truncated_*.bin")
+	malformed = append(malformed, truncated...)
+	out, err = runOutput(nil, append([]string{"validate", withRuns}, malformed...)...)
+	if err == nil || strings.Count(out, ": ok") != 1 || strings.Count(out, "\n") != len(malformed)+1 {
+		t.Errorf("validate should reject the malformed files: %v\n%s", err, out)
+	}
+}
+
+func TestConvertAndAggregate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roaring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rb := readBitmap(t, withRuns)
+	other := readBitmap(t, withoutRuns)
+
+	b64 := filepath.Join(dir, "a.b64")
+	if _, err := runOutput(nil, "convert", "-to", "base64", "-o", b64, withRuns); err != nil {
+		t.Fatal(err)
+	}
+	msgpack, err := runOutput(nil, "convert", "-from", "base64", "-to", "msgpack", b64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	portable, err := runOutput([]byte(msgpack), "convert", "-from", "msgpack", "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	back := roaring.NewBitmap()
+	if err := back.UnmarshalBinary([]byte(portable)); err != nil || !back.Equals(rb) {
+		t.Errorf("the conversions changed the bitmap: %v", err)
+	}
+
+	expected := map[string]*roaring.Bitmap{
+		"and":    roaring.And(rb, other),
+		"or":     roaring.Or(rb, other),
+		"xor":    roaring.Xor(rb, other),
+		"andnot": roaring.AndNot(rb, other),
+	}
+	for op, want := range expected {
+		out, err := runOutput(nil, op, withRuns, withoutRuns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := roaring.NewBitmap()
+		if err := got.UnmarshalBinary([]byte(out)); err != nil || !got.Equals(want) {
+			t.Errorf("%s gave a wrong result: %v", op, err)
+		}
+	}
+
+	out, err := runOutput(nil, "runoptimize", withoutRuns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := roaring.NewBitmap()
+	if err := got.UnmarshalBinary([]byte(out)); err != nil || !got.Equals(other) {
+		t.Errorf("runoptimize changed the bitmap: %v", err)
+	}
+	if got.Stats().RunContainers == 0 {
+		t.Errorf("runoptimize did not create run containers")
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"unknown"},
+		{"dump"},
+		{"dump", withRuns, withoutRuns},
+		{"convert", "-to", "xml", withRuns},
+		{"stats", "-format", "xml", withRuns},
+		{"stats", "-unknown", withRuns},
+		{"stats", "does-not-exist.bin"},
+	} {
+		if _, err := runOutput(nil, args...); err == nil {
+			t.Errorf("%q should fail", args)
+		}
+	}
+	out, err := runOutput(nil, "and", "-h")
+	if err != nil || !strings.Contains(out, "-o file") {
+		t.Errorf("and -h should print the flags: %v\n%s", err, out)
+	}
+}
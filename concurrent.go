@@ -0,0 +1,155 @@
+package roaring
+
+import (
+	"sync"
+)
+
+// ConcurrentBitmap is a Bitmap that can safely be used from several goroutines.
+// Reads and writes are serialized by a Mutex: reads are not safe under a shared
+// lock since they may fill the caches of the containers, such as the
+// cardinality of run containers. Snapshot gives readers an immutable copy that
+// they can use without holding any lock.
+type ConcurrentBitmap struct {
+	mu sync.Mutex
+	rb *Bitmap
+}
+
+// NewConcurrentBitmap creates a new empty ConcurrentBitmap
+func NewConcurrentBitmap() *ConcurrentBitmap {
+	return &ConcurrentBitmap{rb: NewBitmap()}
+}
+
+// ConcurrentBitmapOf generates a new ConcurrentBitmap filled with the specified integers
+func ConcurrentBitmapOf(dat ...uint32) *ConcurrentBitmap {
+	return &ConcurrentBitmap{rb: BitmapOf(dat...)}
+}
+
+// Snapshot returns the current content of the bitmap as a new Bitmap.
+// The containers are shared with the ConcurrentBitmap and marked as needing
+// copy-on-write on both sides, so taking a snapshot does not copy any data and
+// later writes to the ConcurrentBitmap never affect the snapshot.
+// The snapshot is meant to be read only, it is not protected by any lock.
+func (cb *ConcurrentBitmap) Snapshot() *Bitmap {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	ra := cb.rb.highlowcontainer.cloneCopyOnWrite()
+	ra.copyOnWrite = false
+	for _, c := range ra.containers {
+		if rc, ok := c.(*runContainer16); ok {
+			// the cardinality of runs is cached on first use, fill the cache
+			// now so that concurrent readers of the snapshot never write to it
+			rc.cardinality()
+		}
+	}
+	// likewise for the cumulative cardinalities when they are cached
+	ra.cumulativeCardinalities()
+	return &Bitmap{highlowcontainer: *ra}
+}
+
+// Add the integer x to the bitmap
+func (cb *ConcurrentBitmap) Add(x uint32) {
+	cb.mu.Lock()
+	cb.rb.Add(x)
+	cb.mu.Unlock()
+}
+
+// CheckedAdd adds the integer x to the bitmap and return true  if it was added (false if the integer was already present)
+func (cb *ConcurrentBitmap) CheckedAdd(x uint32) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.rb.CheckedAdd(x)
+}
+
+// AddMany add all of the values in dat
+func (cb *ConcurrentBitmap) AddMany(dat []uint32) {
+	cb.mu.Lock()
+	cb.rb.AddMany(dat)
+	cb.mu.Unlock()
+}
+
+// AddRange adds the integers in [rangeStart, rangeEnd) to the bitmap
+func (cb *ConcurrentBitmap) AddRange(rangeStart, rangeEnd uint64) {
+	cb.mu.Lock()
+	cb.rb.AddRange(rangeStart, rangeEnd)
+	cb.mu.Unlock()
+}
+
+// Remove the integer x from the bitmap
+func (cb *ConcurrentBitmap) Remove(x uint32) {
+	cb.mu.Lock()
+	cb.rb.Remove(x)
+	cb.mu.Unlock()
+}
+
+// CheckedRemove removes the integer x from the bitmap and return true if the integer was effectively remove (and false if the integer was not present)
+func (cb *ConcurrentBitmap) CheckedRemove(x uint32) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.rb.CheckedRemove(x)
+}
+
+// RemoveRange removes the integers in [rangeStart, rangeEnd) from the bitmap
+func (cb *ConcurrentBitmap) RemoveRange(rangeStart, rangeEnd uint64) {
+	cb.mu.Lock()
+	cb.rb.RemoveRange(rangeStart, rangeEnd)
+	cb.mu.Unlock()
+}
+
+// Clear removes all content from the bitmap
+func (cb *ConcurrentBitmap) Clear() {
+	cb.mu.Lock()
+	cb.rb.Clear()
+	cb.mu.Unlock()
+}
+
+// Or computes the union between the bitmap and x2, and stores the result in the bitmap.
+// x2 must not be modified concurrently.
+func (cb *ConcurrentBitmap) Or(x2 *Bitmap) {
+	cb.mu.Lock()
+	cb.rb.Or(x2)
+	cb.mu.Unlock()
+}
+
+// And computes the intersection between the bitmap and x2, and stores the result in the bitmap.
+// x2 must not be modified concurrently.
+func (cb *ConcurrentBitmap) And(x2 *Bitmap) {
+	cb.mu.Lock()
+	cb.rb.And(x2)
+	cb.mu.Unlock()
+}
+
+// AndNot computes the difference between the bitmap and x2, and stores the result in the bitmap.
+// x2 must not be modified concurrently.
+func (cb *ConcurrentBitmap) AndNot(x2 *Bitmap) {
+	cb.mu.Lock()
+	cb.rb.AndNot(x2)
+	cb.mu.Unlock()
+}
+
+// RunOptimize attempts to further compress the runs of consecutive values found in the bitmap
+func (cb *ConcurrentBitmap) RunOptimize() {
+	cb.mu.Lock()
+	cb.rb.RunOptimize()
+	cb.mu.Unlock()
+}
+
+// Contains returns true if the integer is contained in the bitmap
+func (cb *ConcurrentBitmap) Contains(x uint32) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.rb.Contains(x)
+}
+
+// GetCardinality returns the number of integers contained in the bitmap
+func (cb *ConcurrentBitmap) GetCardinality() uint64 {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.rb.GetCardinality()
+}
+
+// IsEmpty returns true if the bitmap is empty
+func (cb *ConcurrentBitmap) IsEmpty() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.rb.IsEmpty()
+}
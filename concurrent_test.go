@@ -0,0 +1,130 @@
+package roaring
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConcurrentBitmapBasic(t *testing.T) {
+	Convey("ConcurrentBitmap should behave like a Bitmap", t, func() {
+		cb := NewConcurrentBitmap()
+		So(cb.IsEmpty(), ShouldBeTrue)
+		cb.Add(1)
+		So(cb.CheckedAdd(2), ShouldBeTrue)
+		So(cb.CheckedAdd(2), ShouldBeFalse)
+		cb.AddMany([]uint32{3, 4, 1 << 20})
+		cb.AddRange(100, 200)
+		So(cb.GetCardinality(), ShouldEqual, 105)
+		cb.RemoveRange(150, 200)
+		cb.Remove(1)
+		So(cb.CheckedRemove(2), ShouldBeTrue)
+		So(cb.CheckedRemove(2), ShouldBeFalse)
+		So(cb.Contains(3), ShouldBeTrue)
+		So(cb.Contains(1), ShouldBeFalse)
+		So(cb.GetCardinality(), ShouldEqual, 53)
+
+		cb.Or(BitmapOf(7, 8))
+		cb.AndNot(BitmapOf(8))
+		cb.And(BitmapOf(3, 7, 1<<20))
+		cb.RunOptimize()
+		So(cb.Snapshot().Equals(BitmapOf(3, 7, 1<<20)), ShouldBeTrue)
+		So(ConcurrentBitmapOf(3, 7, 1<<20).Snapshot().Equals(cb.Snapshot()), ShouldBeTrue)
+		cb.Clear()
+		So(cb.IsEmpty(), ShouldBeTrue)
+	})
+}
+
+func TestConcurrentBitmapSnapshot(t *testing.T) {
+	Convey("snapshots should not see later writes, and writes to snapshots should not leak", t, func() {
+		cb := NewConcurrentBitmap()
+		cb.AddRange(0, 100000)
+		cb.RunOptimize()
+		cb.Add(1 << 20)
+		snap := cb.Snapshot()
+
+		cb.Remove(5)
+		cb.RemoveRange(50000, 60000)
+		cb.Add(3 << 20)
+		So(snap.GetCardinality(), ShouldEqual, 100001)
+		So(snap.Contains(5), ShouldBeTrue)
+		So(snap.Contains(3<<20), ShouldBeFalse)
+
+		snap.Add(5 << 20)
+		snap.Remove(1 << 20)
+		So(cb.Contains(5<<20), ShouldBeFalse)
+		So(cb.Contains(1<<20), ShouldBeTrue)
+		So(cb.GetCardinality(), ShouldEqual, 100001-1-10000+1)
+	})
+
+	Convey("readers of snapshots should not race with writers", t, func() {
+		cb := NewConcurrentBitmap()
+		cb.AddRange(0, 1<<17)
+		cb.RunOptimize()
+
+		var wg sync.WaitGroup
+		for w := 0; w < 2; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := uint32(0); i < 2000; i++ {
+					cb.Add(1<<18 + 2*i + uint32(w))
+					cb.Remove(i * 37 % (1 << 17))
+				}
+			}(w)
+		}
+		errs := make(chan string, 4)
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					snap := cb.Snapshot()
+					card := snap.GetCardinality()
+					if uint64(len(snap.ToArray())) != card || snap.GetCardinality() != card {
+						errs <- "snapshot changed while being read"
+						return
+					}
+					cb.Contains(uint32(i))
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for e := range errs {
+			So(e, ShouldBeEmpty)
+		}
+		So(cb.GetCardinality(), ShouldEqual, 1<<17-2000+4000)
+	})
+}
+
+func TestConcurrentBitmapReaders(t *testing.T) {
+	Convey("concurrent readers of a ConcurrentBitmap should not race", t, func() {
+		cb := NewConcurrentBitmap()
+		cb.AddRange(0, 100)
+		x2 := NewBitmap()
+		x2.AddRange(1<<16, 1<<16+1000)
+		x2.AddRange(3<<16, 5<<16)
+		cb.Or(x2)
+
+		var wg sync.WaitGroup
+		cards := make(chan uint64, 4)
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func(r int) {
+				defer wg.Done()
+				if cb.IsEmpty() || !cb.Contains(uint32(r)) || cb.Contains(2<<16) {
+					cards <- 0
+					return
+				}
+				cards <- cb.GetCardinality()
+			}(r)
+		}
+		wg.Wait()
+		close(cards)
+		for card := range cards {
+			So(card, ShouldEqual, 100+1000+2<<16)
+		}
+	})
+}
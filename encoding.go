@@ -0,0 +1,156 @@
+package roaring
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// MarshalJSON implements the json.Marshaler interface for the bitmap, it is
+// encoded as a JSON string holding the base64 of its portable serialization
+// (see Bitmap.WriteTo). Use MarshalJSONRanges or BitmapRanges for a readable
+// encoding.
+func (rb *Bitmap) MarshalJSON() ([]byte, error) {
+	text, err := rb.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(text))
+}
+
+// MarshalJSONRanges encodes the bitmap as a JSON array of [start, last]
+// pairs, bounds included, for example [[1,5],[9,9]]. UnmarshalJSON accepts
+// this encoding as well as the one of MarshalJSON.
+func (rb *Bitmap) MarshalJSONRanges() ([]byte, error) {
+	buf := []byte{'['}
+	rb.forEachRange(func(start, last uint32) {
+		if len(buf) > 1 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, '[')
+		buf = strconv.AppendUint(buf, uint64(start), 10)
+		buf = append(buf, ',')
+		buf = strconv.AppendUint(buf, uint64(last), 10)
+		buf = append(buf, ']')
+	})
+	return append(buf, ']'), nil
+}
+
+// BitmapRanges wraps a bitmap so that it is encoded in JSON by
+// MarshalJSONRanges, for example as a struct field:
+//
+//	type Model struct {
+//		Users roaring.BitmapRanges `json:"users"`
+//	}
+//
+// A nil bitmap is encoded as null, one is allocated when decoding if needed.
+type BitmapRanges struct {
+	*Bitmap
+}
+
+// MarshalJSON implements the json.Marshaler interface with MarshalJSONRanges
+func (r BitmapRanges) MarshalJSON() ([]byte, error) {
+	if r.Bitmap == nil {
+		return []byte("null"), nil
+	}
+	return r.Bitmap.MarshalJSONRanges()
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, see Bitmap.UnmarshalJSON
+func (r *BitmapRanges) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil
+	}
+	if r.Bitmap == nil {
+		r.Bitmap = NewBitmap()
+	}
+	return r.Bitmap.UnmarshalJSON(data)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for the bitmap.
+// It accepts a base64 string as well as an array whose elements are either
+// integers or [start, last] pairs, bounds included. The previous content of
+// the bitmap is discarded.
+func (rb *Bitmap) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		return rb.UnmarshalText([]byte(s))
+	}
+	var elements []json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return err
+	}
+	ranges := make([]Interval, 0, len(elements))
+	for _, e := range elements {
+		var x uint32
+		if err := json.Unmarshal(e, &x); err == nil {
+			ranges = append(ranges, Interval{Start: uint64(x), End: uint64(x) + 1})
+			continue
+		}
+		var r []uint32
+		if err := json.Unmarshal(e, &r); err != nil || len(r) != 2 {
+			return fmt.Errorf("roaring: invalid JSON bitmap element %s", e)
+		}
+		if r[0] > r[1] {
+			return fmt.Errorf("roaring: invalid JSON bitmap range %s", e)
+		}
+		ranges = append(ranges, Interval{Start: uint64(r[0]), End: uint64(r[1]) + 1})
+	}
+	rb.Clear()
+	rb.Or(FromRanges(ranges))
+	return nil
+}
+
+// MarshalText implements the encoding.TextMarshaler interface for the bitmap,
+// the text is the base64 of the portable serialization
+func (rb *Bitmap) MarshalText() ([]byte, error) {
+	data, err := rb.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	text := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(text, data)
+	return text, nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface for the bitmap
+func (rb *Bitmap) UnmarshalText(text []byte) error {
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(text)))
+	n, err := base64.StdEncoding.Decode(data, text)
+	if err != nil {
+		return err
+	}
+	return rb.UnmarshalBinary(data[:n])
+}
+
+// Value implements the database/sql/driver.Valuer interface, the bitmap is
+// stored as its portable serialization, for example in a bytea or blob column
+func (rb *Bitmap) Value() (driver.Value, error) {
+	return rb.MarshalBinary()
+}
+
+// Scan implements the database/sql.Scanner interface. It accepts the
+// portable serialization as []byte, its base64 as a string, and NULL
+// which gives an empty bitmap.
+func (rb *Bitmap) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		rb.Clear()
+		return nil
+	case []byte:
+		return rb.UnmarshalBinary(src)
+	case string:
+		return rb.UnmarshalText([]byte(src))
+	}
+	return fmt.Errorf("roaring: cannot scan a %T into a bitmap", src)
+}
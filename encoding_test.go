@@ -0,0 +1,165 @@
+package roaring
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"math/rand"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var (
+	_ json.Marshaler           = (*Bitmap)(nil)
+	_ json.Unmarshaler         = (*Bitmap)(nil)
+	_ encoding.TextMarshaler   = (*Bitmap)(nil)
+	_ encoding.TextUnmarshaler = (*Bitmap)(nil)
+	_ driver.Valuer            = (*Bitmap)(nil)
+	_ sql.Scanner              = (*Bitmap)(nil)
+	_ json.Marshaler           = BitmapRanges{}
+	_ json.Unmarshaler         = (*BitmapRanges)(nil)
+)
+
+// rangesOf lists the runs of rb one value at a time
+func rangesOf(rb *Bitmap) [][2]uint32 {
+	var answer [][2]uint32
+	for it := rb.Iterator(); it.HasNext(); {
+		x := it.Next()
+		if n := len(answer); n > 0 && answer[n-1][1]+1 == x {
+			answer[n-1][1] = x
+		} else {
+			answer = append(answer, [2]uint32{x, x})
+		}
+	}
+	return answer
+}
+
+func TestEncodingJSON(t *testing.T) {
+	Convey("bitmaps should round trip through JSON in both encodings", t, func() {
+		r := rand.New(rand.NewSource(20))
+		for trial := 0; trial < 20; trial++ {
+			rb := randomBitmapForValidate(r)
+			data, err := json.Marshal(rb)
+			So(err, ShouldBeNil)
+			So(data[0], ShouldEqual, '"')
+			newrb := BitmapOf(1, 2, 3)
+			So(json.Unmarshal(data, newrb), ShouldBeNil)
+			So(newrb.Equals(rb), ShouldBeTrue)
+
+			data, err = rb.MarshalJSONRanges()
+			So(err, ShouldBeNil)
+			var ranges [][2]uint32
+			So(json.Unmarshal(data, &ranges), ShouldBeNil)
+			So(ranges, ShouldResemble, rangesOf(rb))
+			newrb = BitmapOf(1, 2, 3)
+			So(json.Unmarshal(data, newrb), ShouldBeNil)
+			So(newrb.Equals(rb), ShouldBeTrue)
+		}
+	})
+
+	Convey("the ranges form should merge runs across containers", t, func() {
+		rb := BitmapOf(1, 2, 3, 4, 5, 9, MaxUint32)
+		rb.AddRange(1<<16-10, 3<<16+10)
+		data, err := rb.MarshalJSONRanges()
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "[[1,5],[9,9],[65526,196617],[4294967295,4294967295]]")
+		data, err = json.Marshal(BitmapRanges{rb})
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "[[1,5],[9,9],[65526,196617],[4294967295,4294967295]]")
+		data, err = NewBitmap().MarshalJSONRanges()
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "[]")
+	})
+
+	Convey("bitmaps should be usable as struct fields", t, func() {
+		type model struct {
+			Users  *Bitmap      `json:"users"`
+			Empty  *Bitmap      `json:"empty"`
+			Groups BitmapRanges `json:"groups"`
+			None   BitmapRanges `json:"none"`
+		}
+		data, err := json.Marshal(model{Users: BitmapOf(10, 20), Groups: BitmapRanges{BitmapOf(1, 2, 3, 7)}})
+		So(err, ShouldBeNil)
+		So(string(data), ShouldContainSubstring, `"groups":[[1,3],[7,7]],"none":null`)
+		var m model
+		So(json.Unmarshal(data, &m), ShouldBeNil)
+		So(m.Users.ToArray(), ShouldResemble, []uint32{10, 20})
+		So(m.Empty, ShouldBeNil)
+		So(m.Groups.ToArray(), ShouldResemble, []uint32{1, 2, 3, 7})
+		So(m.None.Bitmap, ShouldBeNil)
+
+		b64, err := BitmapOf(5).ToBase64()
+		So(err, ShouldBeNil)
+		So(json.Unmarshal([]byte(`{"users": [1, [5, 7], 3], "groups": "`+b64+`"}`), &m), ShouldBeNil)
+		So(m.Users.ToArray(), ShouldResemble, []uint32{1, 3, 5, 6, 7})
+		So(m.Groups.ToArray(), ShouldResemble, []uint32{5})
+	})
+
+	Convey("values at the end of a container should survive the ranges form", t, func() {
+		for _, data := range []string{`[65535]`, `[5, 65535, 131071]`, `[[65530, 65535], [4294967295, 4294967295]]`} {
+			var expected []uint32
+			rb := NewBitmap()
+			So(json.Unmarshal([]byte(data), rb), ShouldBeNil)
+			var elements []interface{}
+			So(json.Unmarshal([]byte(data), &elements), ShouldBeNil)
+			for _, e := range elements {
+				switch e := e.(type) {
+				case float64:
+					expected = append(expected, uint32(e))
+				case []interface{}:
+					for x := uint64(e[0].(float64)); x <= uint64(e[1].(float64)); x++ {
+						expected = append(expected, uint32(x))
+					}
+				}
+			}
+			So(rb.ToArray(), ShouldResemble, expected)
+			out, err := rb.MarshalJSONRanges()
+			So(err, ShouldBeNil)
+			back := NewBitmap()
+			So(json.Unmarshal(out, back), ShouldBeNil)
+			So(back.Equals(rb), ShouldBeTrue)
+		}
+	})
+
+	Convey("invalid JSON should give an error", t, func() {
+		rb := NewBitmap()
+		for _, s := range []string{`{}`, `[-1]`, `[1.5]`, `[[1]]`, `[[1,2,3]]`, `[[5,1]]`, `[4294967296]`, `"%%%"`, `"AAAA"`} {
+			So(json.Unmarshal([]byte(s), rb), ShouldNotBeNil)
+		}
+	})
+}
+
+func TestEncodingTextAndSQL(t *testing.T) {
+	Convey("bitmaps should round trip through text and sql", t, func() {
+		rb := BitmapOf(1, 1000, 100000)
+		rb.AddRange(1<<20, 1<<21)
+
+		text, err := rb.MarshalText()
+		So(err, ShouldBeNil)
+		b64, err := rb.ToBase64()
+		So(err, ShouldBeNil)
+		So(string(text), ShouldEqual, b64)
+		newrb := NewBitmap()
+		So(newrb.UnmarshalText(text), ShouldBeNil)
+		So(newrb.Equals(rb), ShouldBeTrue)
+
+		v, err := rb.Value()
+		So(err, ShouldBeNil)
+		data, ok := v.([]byte)
+		So(ok, ShouldBeTrue)
+		So(driver.IsValue(v), ShouldBeTrue)
+
+		newrb = NewBitmap()
+		So(newrb.Scan(data), ShouldBeNil)
+		So(newrb.Equals(rb), ShouldBeTrue)
+		newrb = NewBitmap()
+		So(newrb.Scan(b64), ShouldBeNil)
+		So(newrb.Equals(rb), ShouldBeTrue)
+		So(newrb.Scan(nil), ShouldBeNil)
+		So(newrb.IsEmpty(), ShouldBeTrue)
+		So(newrb.Scan(42), ShouldNotBeNil)
+		So(newrb.Scan([]byte{1, 2, 3}), ShouldNotBeNil)
+	})
+}
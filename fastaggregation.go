@@ -100,6 +100,107 @@ main:
 	return answer
 }
 
+// Xor function that requires repairAfterLazy
+func lazyXOR(x1, x2 *Bitmap) *Bitmap {
+	answer := NewBitmap()
+	pos1 := 0
+	pos2 := 0
+	length1 := x1.highlowcontainer.size()
+	length2 := x2.highlowcontainer.size()
+main:
+	for (pos1 < length1) && (pos2 < length2) {
+		s1 := x1.highlowcontainer.getKeyAtIndex(pos1)
+		s2 := x2.highlowcontainer.getKeyAtIndex(pos2)
+
+		for {
+			if s1 < s2 {
+				answer.highlowcontainer.appendCopy(x1.highlowcontainer, pos1)
+				pos1++
+				if pos1 == length1 {
+					break main
+				}
+				s1 = x1.highlowcontainer.getKeyAtIndex(pos1)
+			} else if s1 > s2 {
+				answer.highlowcontainer.appendCopy(x2.highlowcontainer, pos2)
+				pos2++
+				if pos2 == length2 {
+					break main
+				}
+				s2 = x2.highlowcontainer.getKeyAtIndex(pos2)
+			} else {
+				c := x1.highlowcontainer.getContainerAtIndex(pos1).lazyXOR(x2.highlowcontainer.getContainerAtIndex(pos2))
+				// lazy bitmap containers have an invalid cardinality, they are removed by repairAfterLazy if empty
+				if c.getCardinality() != 0 {
+					answer.highlowcontainer.appendContainer(s1, c, false)
+				}
+				pos1++
+				pos2++
+				if (pos1 == length1) || (pos2 == length2) {
+					break main
+				}
+				s1 = x1.highlowcontainer.getKeyAtIndex(pos1)
+				s2 = x2.highlowcontainer.getKeyAtIndex(pos2)
+			}
+		}
+	}
+	if pos1 == length1 {
+		answer.highlowcontainer.appendCopyMany(x2.highlowcontainer, pos2, length2)
+	} else if pos2 == length2 {
+		answer.highlowcontainer.appendCopyMany(x1.highlowcontainer, pos1, length1)
+	}
+	return answer
+}
+
+// In-place Xor function that requires repairAfterLazy
+func (x1 *Bitmap) lazyXOR(x2 *Bitmap) *Bitmap {
+	answer := NewBitmap()
+	pos1 := 0
+	pos2 := 0
+	length1 := x1.highlowcontainer.size()
+	length2 := x2.highlowcontainer.size()
+main:
+	for (pos1 < length1) && (pos2 < length2) {
+		s1 := x1.highlowcontainer.getKeyAtIndex(pos1)
+		s2 := x2.highlowcontainer.getKeyAtIndex(pos2)
+
+		for {
+			if s1 < s2 {
+				answer.highlowcontainer.appendWithoutCopy(x1.highlowcontainer, pos1)
+				pos1++
+				if pos1 == length1 {
+					break main
+				}
+				s1 = x1.highlowcontainer.getKeyAtIndex(pos1)
+			} else if s1 > s2 {
+				answer.highlowcontainer.appendCopy(x2.highlowcontainer, pos2)
+				pos2++
+				if pos2 == length2 {
+					break main
+				}
+				s2 = x2.highlowcontainer.getKeyAtIndex(pos2)
+			} else {
+				c := x1.highlowcontainer.getWritableContainerAtIndex(pos1).lazyIXOR(x2.highlowcontainer.getContainerAtIndex(pos2))
+				if c.getCardinality() != 0 {
+					answer.highlowcontainer.appendContainer(s1, c, false)
+				}
+				pos1++
+				pos2++
+				if (pos1 == length1) || (pos2 == length2) {
+					break main
+				}
+				s1 = x1.highlowcontainer.getKeyAtIndex(pos1)
+				s2 = x2.highlowcontainer.getKeyAtIndex(pos2)
+			}
+		}
+	}
+	if pos1 == length1 {
+		answer.highlowcontainer.appendCopyMany(x2.highlowcontainer, pos2, length2)
+	} else if pos2 == length2 {
+		answer.highlowcontainer.appendWithoutCopyMany(x1.highlowcontainer, pos1, length1)
+	}
+	return answer
+}
+
 // to be called after lazy aggregates
 func (x1 *Bitmap) repairAfterLazy() {
 	for pos := 0; pos < x1.highlowcontainer.size(); pos++ {
@@ -109,7 +210,11 @@ func (x1 *Bitmap) repairAfterLazy() {
 			if c.(*bitmapContainer).cardinality == invalidCardinality {
 				c = x1.highlowcontainer.getWritableContainerAtIndex(pos)
 				c.(*bitmapContainer).computeCardinality()
-				if c.(*bitmapContainer).getCardinality() <= arrayDefaultMaxSize {
+				if c.(*bitmapContainer).getCardinality() == 0 {
+					// a lazy xor may leave empty containers
+					x1.highlowcontainer.removeAtIndex(pos)
+					pos--
+				} else if c.(*bitmapContainer).getCardinality() <= arrayDefaultMaxSize {
 					x1.highlowcontainer.setContainerAtIndex(pos, c.(*bitmapContainer).toArrayContainer())
 				}
 			}
@@ -150,13 +255,76 @@ func FastOr(bitmaps ...*Bitmap) *Bitmap {
 	return answer
 }
 
+// FastXor computes the symmetric difference between many bitmaps quickly, as opposed to having to call Xor repeatedly.
+// Like FastOr, the cardinality of the containers is only computed at the end.
+func FastXor(bitmaps ...*Bitmap) *Bitmap {
+	if len(bitmaps) == 0 {
+		return NewBitmap()
+	} else if len(bitmaps) == 1 {
+		return bitmaps[0].Clone()
+	}
+	answer := lazyXOR(bitmaps[0], bitmaps[1])
+	for _, bm := range bitmaps[2:] {
+		answer = answer.lazyXOR(bm)
+	}
+	answer.repairAfterLazy()
+	return answer
+}
+
+// FastAndNot computes the difference between base and the union of the subtract bitmaps,
+// as opposed to having to call AndNot repeatedly. The keys are merged in a single pass:
+// only the containers of subtract whose key is in base are read, they are or-ed lazily
+// and the result is subtracted once from the container of base. The containers of base
+// that no other bitmap shares are copied on write. The bitmaps are not modified.
+func FastAndNot(base *Bitmap, subtract ...*Bitmap) *Bitmap {
+	answer := NewBitmap()
+	ra := &base.highlowcontainer
+	// pos[j] is the index of the first key of subtract[j] that may still be in base
+	pos := make([]int, len(subtract))
+	for i, key := range ra.keys {
+		var union container
+		owned := false
+		for j, bm := range subtract {
+			sa := &bm.highlowcontainer
+			if pos[j] < sa.size() && sa.keys[pos[j]] < key {
+				pos[j] = sa.advanceUntil(key, pos[j])
+			}
+			if pos[j] >= sa.size() || sa.keys[pos[j]] != key {
+				continue
+			}
+			c := sa.getContainerAtIndex(pos[j])
+			if union == nil {
+				union = c
+			} else if !owned {
+				union = union.lazyOR(c)
+				owned = true
+			} else {
+				union = union.lazyIOR(c)
+			}
+		}
+		if union == nil {
+			answer.highlowcontainer.appendCopy(*ra, i)
+			continue
+		}
+		if bc, ok := union.(*bitmapContainer); ok && bc.cardinality == invalidCardinality {
+			bc.computeCardinality()
+		}
+		c := ra.getContainerAtIndex(i).andNot(union)
+		if c.getCardinality() > 0 {
+			answer.highlowcontainer.appendContainer(key, c, false)
+		}
+	}
+	return answer
+}
+
 // HeapOr computes the union between many bitmaps quickly using a heap.
 // It might be faster than calling Or repeatedly.
 func HeapOr(bitmaps ...*Bitmap) *Bitmap {
 	if len(bitmaps) == 0 {
 		return NewBitmap()
 	}
-	// TODO:  for better speed, we could do the operation lazily, see Java implementation
+	// the intermediate unions are lazy, as in the Java implementation:
+	// their cardinality is only computed at the end
 	pq := make(priorityQueue, len(bitmaps))
 	for i, bm := range bitmaps {
 		pq[i] = &item{bm, i}
@@ -166,9 +334,11 @@ func HeapOr(bitmaps ...*Bitmap) *Bitmap {
 	for pq.Len() > 1 {
 		x1 := heap.Pop(&pq).(*item)
 		x2 := heap.Pop(&pq).(*item)
-		heap.Push(&pq, &item{Or(x1.value, x2.value), 0})
+		heap.Push(&pq, &item{lazyOR(x1.value, x2.value), 0})
 	}
-	return heap.Pop(&pq).(*item).value
+	answer := heap.Pop(&pq).(*item).value
+	answer.repairAfterLazy()
+	return answer
 }
 
 // HeapXor computes the symmetric difference between many bitmaps quickly (as opposed to calling Xor repeated).
@@ -188,7 +358,256 @@ func HeapXor(bitmaps ...*Bitmap) *Bitmap {
 	for pq.Len() > 1 {
 		x1 := heap.Pop(&pq).(*item)
 		x2 := heap.Pop(&pq).(*item)
-		heap.Push(&pq, &item{Xor(x1.value, x2.value), 0})
+		heap.Push(&pq, &item{lazyXOR(x1.value, x2.value), 0})
+	}
+	answer := heap.Pop(&pq).(*item).value
+	answer.repairAfterLazy()
+	return answer
+}
+
+// newContainerQueue returns a containerPriorityQueue holding the first container of each non-empty bitmap
+func newContainerQueue(bitmaps []*Bitmap) containerPriorityQueue {
+	pq := make(containerPriorityQueue, 0, len(bitmaps))
+	for _, bm := range bitmaps {
+		if !bm.IsEmpty() {
+			pq = append(pq, &containeritem{bm, 0, len(pq)})
+		}
+	}
+	heap.Init(&pq)
+	return pq
+}
+
+// popContainers removes from pq the items whose current key is the smallest one,
+// the items are appended to group and returned ordered by decreasing cardinality
+func popContainers(pq *containerPriorityQueue, group []*containeritem) []*containeritem {
+	x := heap.Pop(pq).(*containeritem)
+	key := x.value.highlowcontainer.getKeyAtIndex(x.keyindex)
+	group = append(group, x)
+	for pq.Len() > 0 && (*pq)[0].value.highlowcontainer.getKeyAtIndex((*pq)[0].keyindex) == key {
+		group = append(group, heap.Pop(pq).(*containeritem))
+	}
+	return group
+}
+
+// pushNextContainers puts back in pq the items of group that have containers left
+func pushNextContainers(pq *containerPriorityQueue, group []*containeritem) {
+	for _, x := range group {
+		x.keyindex++
+		if x.keyindex < x.value.highlowcontainer.size() {
+			heap.Push(pq, x)
+		}
+	}
+}
+
+// orContainerInto sets in words the bits of the values of c
+func orContainerInto(words []uint64, c container) {
+	switch x := c.(type) {
+	case *arrayContainer:
+		for _, v := range x.content {
+			words[uint(v)>>6] |= uint64(1) << (v % 64)
+		}
+	case *bitmapContainer:
+		for k, w := range x.bitmap {
+			words[k] |= w
+		}
+	case *runContainer16:
+		for _, p := range x.iv {
+			setBitmapRange(words, int(p.start), int(p.last)+1)
+		}
+	}
+}
+
+// andContainerInto clears in words the bits of the values that are not in c
+func andContainerInto(words []uint64, c container) {
+	switch x := c.(type) {
+	case *arrayContainer:
+		tmp := make([]uint64, len(words))
+		orContainerInto(tmp, x)
+		for k := range words {
+			words[k] &= tmp[k]
+		}
+	case *bitmapContainer:
+		for k, w := range x.bitmap {
+			words[k] &= w
+		}
+	case *runContainer16:
+		start := 0
+		for _, p := range x.iv {
+			resetBitmapRange(words, start, int(p.start))
+			start = int(p.last) + 1
+		}
+		resetBitmapRange(words, start, maxLowBit+1)
+	}
+}
+
+// FastOrCardinality computes the cardinality of the union of many bitmaps without
+// computing the union itself: the containers sharing a key are merged one key at a time.
+func FastOrCardinality(bitmaps ...*Bitmap) uint64 {
+	pq := newContainerQueue(bitmaps)
+	words := make([]uint64, (1<<16)/64)
+	group := make([]*containeritem, 0, len(bitmaps))
+	answer := uint64(0)
+	for pq.Len() > 0 {
+		group = popContainers(&pq, group[:0])
+		c1 := group[0].value.highlowcontainer.getContainerAtIndex(group[0].keyindex)
+		switch len(group) {
+		case 1:
+			answer += uint64(c1.getCardinality())
+		case 2:
+			c2 := group[1].value.highlowcontainer.getContainerAtIndex(group[1].keyindex)
+			b1, ok1 := c1.(*bitmapContainer)
+			b2, ok2 := c2.(*bitmapContainer)
+			if ok1 && ok2 {
+				answer += popcntOrSlice(b1.bitmap, b2.bitmap)
+			} else {
+				answer += uint64(c1.getCardinality() + c2.getCardinality() - c1.andCardinality(c2))
+			}
+		default:
+			fill(words, 0)
+			for _, x := range group {
+				orContainerInto(words, x.value.highlowcontainer.getContainerAtIndex(x.keyindex))
+			}
+			answer += popcntSlice(words)
+		}
+		pushNextContainers(&pq, group)
+	}
+	return answer
+}
+
+// FastAndCardinality computes the cardinality of the intersection of many bitmaps without
+// computing the intersection itself: the containers sharing a key are merged one key at a time.
+func FastAndCardinality(bitmaps ...*Bitmap) uint64 {
+	if len(bitmaps) == 0 {
+		return 0
+	}
+	for _, bm := range bitmaps {
+		if bm.IsEmpty() {
+			return 0
+		}
+	}
+	pq := newContainerQueue(bitmaps)
+	words := make([]uint64, (1<<16)/64)
+	group := make([]*containeritem, 0, len(bitmaps))
+	answer := uint64(0)
+	for pq.Len() == len(bitmaps) {
+		group = popContainers(&pq, group[:0])
+		if len(group) == len(bitmaps) {
+			answer += uint64(andCardinalityOfGroup(words, group))
+		}
+		pushNextContainers(&pq, group)
+	}
+	return answer
+}
+
+// andCardinalityOfGroup returns the cardinality of the intersection of the
+// containers of group, which is ordered by decreasing cardinality
+func andCardinalityOfGroup(words []uint64, group []*containeritem) int {
+	smallest := group[len(group)-1].value.highlowcontainer.getContainerAtIndex(group[len(group)-1].keyindex)
+	if len(group) == 1 {
+		return smallest.getCardinality()
+	}
+	if len(group) == 2 {
+		return smallest.andCardinality(group[0].value.highlowcontainer.getContainerAtIndex(group[0].keyindex))
+	}
+	if ac, ok := smallest.(*arrayContainer); ok {
+		// check the few values of the array against the other containers
+		answer := 0
+	values:
+		for _, v := range ac.content {
+			for _, x := range group[:len(group)-1] {
+				if !x.value.highlowcontainer.getContainerAtIndex(x.keyindex).contains(v) {
+					continue values
+				}
+			}
+			answer++
+		}
+		return answer
+	}
+	fill(words, 0)
+	orContainerInto(words, smallest)
+	for _, x := range group[:len(group)-1] {
+		andContainerInto(words, x.value.highlowcontainer.getContainerAtIndex(x.keyindex))
+	}
+	return int(popcntSlice(words))
+}
+
+// ThresholdOr returns the integers that are in at least k of the bitmaps.
+// ThresholdOr(1, ...) is the union and ThresholdOr(len(bitmaps), ...) the intersection.
+// For each key, the containers are added in a bit-sliced counter over the 2^16 values
+// of the key, which is then compared with k.
+func ThresholdOr(k int, bitmaps ...*Bitmap) *Bitmap {
+	if k <= 1 {
+		return FastOr(bitmaps...)
+	} else if k > len(bitmaps) {
+		return NewBitmap()
+	} else if k == len(bitmaps) {
+		return FastAnd(bitmaps...)
+	}
+	nslices := 0
+	for len(bitmaps)>>uint(nslices) != 0 {
+		nslices++
+	}
+	// slices[i] holds bit i of the count of each value
+	slices := make([][]uint64, nslices)
+	for i := range slices {
+		slices[i] = make([]uint64, (1<<16)/64)
+	}
+	words := make([]uint64, (1<<16)/64)
+
+	answer := NewBitmap()
+	pq := newContainerQueue(bitmaps)
+	group := make([]*containeritem, 0, len(bitmaps))
+	for pq.Len() >= k {
+		group = popContainers(&pq, group[:0])
+		if len(group) >= k {
+			for _, s := range slices {
+				fill(s, 0)
+			}
+			for _, x := range group {
+				fill(words, 0)
+				orContainerInto(words, x.value.highlowcontainer.getContainerAtIndex(x.keyindex))
+				addToSlices(slices, words)
+			}
+			bc := newBitmapContainer()
+			thresholdOfSlices(bc.bitmap, slices, k)
+			bc.computeCardinality()
+			if bc.cardinality > 0 {
+				key := group[0].value.highlowcontainer.getKeyAtIndex(group[0].keyindex)
+				var c container = bc
+				if bc.cardinality <= arrayDefaultMaxSize {
+					c = bc.toArrayContainer()
+				}
+				answer.highlowcontainer.appendContainer(key, c, false)
+			}
+		}
+		pushNextContainers(&pq, group)
+	}
+	return answer
+}
+
+// addToSlices adds the bits of words to the bit-sliced counter slices
+func addToSlices(slices [][]uint64, words []uint64) {
+	for w, carry := range words {
+		for i := 0; carry != 0 && i < len(slices); i++ {
+			s := slices[i][w]
+			slices[i][w] = s ^ carry
+			carry &= s
+		}
+	}
+}
+
+// thresholdOfSlices sets in out the bits whose count in the bit-sliced counter slices is at least k
+func thresholdOfSlices(out []uint64, slices [][]uint64, k int) {
+	for w := range out {
+		gt, eq := uint64(0), ^uint64(0)
+		for i := len(slices) - 1; i >= 0; i-- {
+			if k&(1<<uint(i)) != 0 {
+				eq &= slices[i][w]
+			} else {
+				gt |= eq & slices[i][w]
+				eq &^= slices[i][w]
+			}
+		}
+		out[w] = gt | eq
 	}
-	return heap.Pop(&pq).(*item).value
 }
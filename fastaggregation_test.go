@@ -4,8 +4,10 @@ package roaring
 
 import (
 	"container/heap"
-	. "github.com/smartystreets/goconvey/convey"
+	"math/rand"
 	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
 )
 
 func TestFastAggregations(t *testing.T) {
@@ -280,3 +282,245 @@ func TestFastAggregationsXOR_run(t *testing.T) {
 		So(HeapXor(rb1, rb2, rb3).Equals(bigxor), ShouldEqual, true)
 	})
 }
+
+func TestFastAggregationsLazy(t *testing.T) {
+	Convey("lazy aggregations should match the pairwise operations", t, func() {
+		r := rand.New(rand.NewSource(2021))
+		for trial := 0; trial < 100; trial++ {
+			bitmaps := make([]*Bitmap, 1+r.Intn(6))
+			before := make([]*Bitmap, len(bitmaps))
+			for i := range bitmaps {
+				bitmaps[i] = randomBitmapForValidate(r)
+				before[i] = bitmaps[i].Clone()
+			}
+			or, xor := bitmaps[0].Clone(), bitmaps[0].Clone()
+			andnot := bitmaps[0].Clone()
+			for _, bm := range bitmaps[1:] {
+				or.Or(bm)
+				xor.Xor(bm)
+				andnot.AndNot(bm)
+			}
+			for _, x := range []*Bitmap{HeapOr(bitmaps...), FastOr(bitmaps...)} {
+				So(x.Equals(or), ShouldBeTrue)
+				So(x.Validate(), ShouldBeNil)
+			}
+			for _, x := range []*Bitmap{HeapXor(bitmaps...), FastXor(bitmaps...)} {
+				So(x.Equals(xor), ShouldBeTrue)
+				So(x.Validate(), ShouldBeNil)
+			}
+			x := FastAndNot(bitmaps[0], bitmaps[1:]...)
+			So(x.Equals(andnot), ShouldBeTrue)
+			So(x.Validate(), ShouldBeNil)
+			// a xor with itself leaves only empty containers behind
+			x = FastXor(bitmaps[0], bitmaps[0].Clone())
+			So(x.IsEmpty(), ShouldBeTrue)
+			So(x.Validate(), ShouldBeNil)
+			for i := range bitmaps {
+				So(bitmaps[i].Equals(before[i]), ShouldBeTrue)
+			}
+		}
+	})
+
+	Convey("lazy aggregations of nothing", t, func() {
+		So(FastXor().IsEmpty(), ShouldBeTrue)
+		So(HeapOr().IsEmpty(), ShouldBeTrue)
+		So(FastAndNot(BitmapOf(1, 2), NewBitmap()).GetCardinality(), ShouldEqual, 2)
+		So(FastAndNot(BitmapOf(1, 2)).GetCardinality(), ShouldEqual, 2)
+		So(FastAndNot(NewBitmap(), BitmapOf(1, 2)).IsEmpty(), ShouldBeTrue)
+		So(FastXor(BitmapOf(1, 2)).GetCardinality(), ShouldEqual, 2)
+	})
+}
+
+func TestFastAndNotSharing(t *testing.T) {
+	Convey("FastAndNot should only copy the containers it does not change", t, func() {
+		base := BitmapOf(1, 2, 1<<16+1, 1<<16+2, 2<<16+1)
+		base.AddRange(3<<16, 3<<16+10000)
+		sub := BitmapOf(1<<16+1, 5<<16)
+		sub.AddRange(3<<16+5000, 3<<16+6000)
+		before := base.Clone()
+
+		answer := FastAndNot(base, sub, sub, BitmapOf(6<<16))
+		So(answer.ToArray()[:4], ShouldResemble, []uint32{1, 2, 1<<16 + 2, 2<<16 + 1})
+		So(answer.GetCardinality(), ShouldEqual, 4+9000)
+		So(answer.Validate(), ShouldBeNil)
+
+		// the untouched containers are shared copy-on-write
+		answer.Add(3)
+		answer.Add(2<<16 + 5)
+		answer.RemoveRange(3<<16, 3<<16+100)
+		So(base.Equals(before), ShouldBeTrue)
+		base.Add(4)
+		So(answer.Contains(4), ShouldBeFalse)
+
+		So(FastAndNot(base, base).IsEmpty(), ShouldBeTrue)
+	})
+}
+
+func benchmarkAggregationInput() []*Bitmap {
+	r := rand.New(rand.NewSource(0))
+	bitmaps := make([]*Bitmap, 100)
+	for i := range bitmaps {
+		bitmaps[i] = NewBitmap()
+		for j := 0; j < 50000; j++ {
+			bitmaps[i].Add(uint32(r.Intn(1 << 22)))
+		}
+	}
+	return bitmaps
+}
+
+func BenchmarkFastAggregationsOrPairwise(b *testing.B) {
+	bitmaps := benchmarkAggregationInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		answer := bitmaps[0].Clone()
+		for _, bm := range bitmaps[1:] {
+			answer.Or(bm)
+		}
+	}
+}
+
+func BenchmarkFastAggregationsFastOr(b *testing.B) {
+	bitmaps := benchmarkAggregationInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FastOr(bitmaps...)
+	}
+}
+
+func BenchmarkFastAggregationsHeapOr(b *testing.B) {
+	bitmaps := benchmarkAggregationInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		HeapOr(bitmaps...)
+	}
+}
+
+func BenchmarkFastAggregationsXorPairwise(b *testing.B) {
+	bitmaps := benchmarkAggregationInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		answer := bitmaps[0].Clone()
+		for _, bm := range bitmaps[1:] {
+			answer.Xor(bm)
+		}
+	}
+}
+
+func BenchmarkFastAggregationsFastXor(b *testing.B) {
+	bitmaps := benchmarkAggregationInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FastXor(bitmaps...)
+	}
+}
+
+func BenchmarkFastAggregationsHeapXor(b *testing.B) {
+	bitmaps := benchmarkAggregationInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		HeapXor(bitmaps...)
+	}
+}
+
+func BenchmarkFastAggregationsAndNotPairwise(b *testing.B) {
+	bitmaps := benchmarkAggregationInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		answer := bitmaps[0].Clone()
+		for _, bm := range bitmaps[1:] {
+			answer.AndNot(bm)
+		}
+	}
+}
+
+func BenchmarkFastAggregationsFastAndNot(b *testing.B) {
+	bitmaps := benchmarkAggregationInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FastAndNot(bitmaps[0], bitmaps[1:]...)
+	}
+}
+
+func TestFastAggregationsCardinality(t *testing.T) {
+	Convey("aggregate cardinalities should match the materialized aggregates", t, func() {
+		r := rand.New(rand.NewSource(2022))
+		for trial := 0; trial < 100; trial++ {
+			bitmaps := make([]*Bitmap, 1+r.Intn(6))
+			for i := range bitmaps {
+				bitmaps[i] = randomBitmapForValidate(r)
+				if r.Intn(3) == 0 {
+					// share some containers so that intersections are not empty
+					bitmaps[i].Or(bitmaps[0])
+				}
+			}
+			So(FastOrCardinality(bitmaps...), ShouldEqual, FastOr(bitmaps...).GetCardinality())
+			So(FastAndCardinality(bitmaps...), ShouldEqual, FastAnd(bitmaps...).GetCardinality())
+		}
+	})
+
+	Convey("aggregate cardinalities of nothing", t, func() {
+		So(FastOrCardinality(), ShouldEqual, 0)
+		So(FastAndCardinality(), ShouldEqual, 0)
+		So(FastAndCardinality(BitmapOf(1, 2), NewBitmap()), ShouldEqual, 0)
+		So(FastOrCardinality(BitmapOf(1, 2), NewBitmap()), ShouldEqual, 2)
+	})
+}
+
+func BenchmarkFastAggregationsOrCardinality(b *testing.B) {
+	bitmaps := benchmarkAggregationInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FastOrCardinality(bitmaps...)
+	}
+}
+
+func BenchmarkFastAggregationsAndCardinality(b *testing.B) {
+	bitmaps := benchmarkAggregationInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FastAndCardinality(bitmaps[:3]...)
+	}
+}
+
+func TestFastAggregationsThreshold(t *testing.T) {
+	Convey("ThresholdOr should keep the values found in at least k bitmaps", t, func() {
+		r := rand.New(rand.NewSource(2023))
+		for trial := 0; trial < 50; trial++ {
+			bitmaps := make([]*Bitmap, 1+r.Intn(10))
+			counts := make(map[uint32]int)
+			for i := range bitmaps {
+				bitmaps[i] = randomBitmapForValidate(r)
+				if r.Intn(2) == 0 {
+					bitmaps[i].Or(bitmaps[0])
+				}
+				for _, v := range bitmaps[i].ToArray() {
+					counts[v]++
+				}
+			}
+			for k := 0; k <= len(bitmaps)+1; k++ {
+				expected := NewBitmap()
+				for v, c := range counts {
+					if c >= k {
+						expected.Add(v)
+					}
+				}
+				x := ThresholdOr(k, bitmaps...)
+				So(x.Equals(expected), ShouldBeTrue)
+				So(x.Validate(), ShouldBeNil)
+			}
+		}
+	})
+
+	Convey("ThresholdOr with no bitmap", t, func() {
+		So(ThresholdOr(0).IsEmpty(), ShouldBeTrue)
+		So(ThresholdOr(2).IsEmpty(), ShouldBeTrue)
+	})
+}
+
+func BenchmarkFastAggregationsThresholdOr(b *testing.B) {
+	bitmaps := benchmarkAggregationInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ThresholdOr(3, bitmaps[:10]...)
+	}
+}
@@ -0,0 +1,632 @@
+//go:build go1.18
+// +build go1.18
+
+package roaring
+
+// Native fuzz targets, run them with for example
+//
+//	go test -run=NONE -fuzz=FuzzBitmapDifferential
+//
+// Failing inputs are saved under testdata/fuzz and replayed by go test.
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/willf/bitset"
+)
+
+// fuzzUniverse bounds the values of the differential fuzzer, it spans
+// several containers while keeping the reference bitset small
+const fuzzUniverse = 4 << 16
+
+// fuzzReader consumes the fuzzer input, it returns zeros once it is exhausted
+type fuzzReader struct {
+	data []byte
+}
+
+func (r *fuzzReader) done() bool {
+	return len(r.data) == 0
+}
+
+func (r *fuzzReader) byte() byte {
+	if len(r.data) == 0 {
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *fuzzReader) uint16() uint16 {
+	return uint16(r.byte()) | uint16(r.byte())<<8
+}
+
+// value returns a value of the universe
+func (r *fuzzReader) value() uint32 {
+	return uint32(r.byte()%(fuzzUniverse>>16))<<16 | uint32(r.uint16())
+}
+
+// interval returns a range [start, end) of the universe, possibly crossing containers
+func (r *fuzzReader) interval() (uint64, uint64) {
+	start := uint64(r.value())
+	end := start + uint64(r.uint16())<<(r.byte()%3)
+	if end > fuzzUniverse {
+		end = fuzzUniverse
+	}
+	return start, end
+}
+
+func bitsetOf(bs *bitset.BitSet) []uint32 {
+	answer := []uint32{}
+	for i, ok := bs.NextSet(0); ok; i, ok = bs.NextSet(i + 1) {
+		answer = append(answer, uint32(i))
+	}
+	return answer
+}
+
+// bitsetWords calls f with each word of bs covering [start, end) and the
+// mask of the bits of the range in that word
+func bitsetWords(bs *bitset.BitSet, start, end uint64, f func(w *uint64, mask uint64)) {
+	words := bs.Bytes()
+	for start < end {
+		next := (start/64 + 1) * 64
+		mask := ^uint64(0) << (start % 64)
+		if end < next {
+			mask &= ^uint64(0) >> (next - end)
+			next = end
+		}
+		f(&words[start/64], mask)
+		start = next
+	}
+}
+
+func bitsetSetRange(bs *bitset.BitSet, start, end uint64) {
+	bitsetWords(bs, start, end, func(w *uint64, mask uint64) { *w |= mask })
+}
+
+func bitsetClearRange(bs *bitset.BitSet, start, end uint64) {
+	bitsetWords(bs, start, end, func(w *uint64, mask uint64) { *w &^= mask })
+}
+
+func bitsetFlipRange(bs *bitset.BitSet, start, end uint64) {
+	bitsetWords(bs, start, end, func(w *uint64, mask uint64) { *w ^= mask })
+}
+
+func bitsetRangeCount(bs *bitset.BitSet, start, end uint64) uint64 {
+	count := uint64(0)
+	bitsetWords(bs, start, end, func(w *uint64, mask uint64) { count += popcount(*w & mask) })
+	return count
+}
+
+// sameAsBitset returns true if rb holds the values of bs, it compares whole
+// words so that large bitmaps are checked quickly
+func sameAsBitset(rb *Bitmap, bs *bitset.BitSet) bool {
+	got := bitset.New(bs.Len())
+	for _, r := range rb.Ranges() {
+		if r.End > uint64(bs.Len()) {
+			return false
+		}
+		bitsetSetRange(got, r.Start, r.End)
+	}
+	return got.Equal(bs)
+}
+
+// checkAgainstBitset compares the read-only operations of rb to the
+// reference, the iterators are only checked if thorough is set
+func checkAgainstBitset(t *testing.T, rb *Bitmap, bs *bitset.BitSet, thorough bool) {
+	if err := rb.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !sameAsBitset(rb, bs) {
+		t.Fatalf("bitmap of cardinality %d differs from the reference of cardinality %d", rb.GetCardinality(), bs.Count())
+	}
+	card := uint64(bs.Count())
+	if rb.GetCardinality() != card || rb.IsEmpty() != (card == 0) {
+		t.Fatalf("cardinality %d, expected %d", rb.GetCardinality(), card)
+	}
+	if card == 0 {
+		return
+	}
+	min, _ := bs.NextSet(0)
+	if rb.Minimum() != uint32(min) || !bs.Test(uint(rb.Maximum())) || bitsetRangeCount(bs, uint64(rb.Maximum())+1, uint64(bs.Len())) != 0 {
+		t.Fatalf("minimum %d and maximum %d differ from the reference", rb.Minimum(), rb.Maximum())
+	}
+	for k := uint(0); k < 16; k++ {
+		v, ok := bs.NextSet(k * bs.Len() / 16)
+		if !ok {
+			break
+		}
+		rank := bitsetRangeCount(bs, 0, uint64(v)+1)
+		if got := rb.Rank(uint32(v)); got != rank {
+			t.Fatalf("Rank(%d) = %d, expected %d", v, got, rank)
+		}
+		if s, err := rb.Select(uint32(rank - 1)); err != nil || s != uint32(v) {
+			t.Fatalf("Select(%d) = %d, %v, expected %d", rank-1, s, err, v)
+		}
+	}
+	if !thorough {
+		return
+	}
+	expected := bitsetOf(bs)
+	if !equalUint32s(rb.ToArray(), expected) {
+		t.Fatal("ToArray differs from the reference")
+	}
+	it := rb.ReverseIterator()
+	for i := len(expected) - 1; i >= 0; i-- {
+		if !it.HasNext() || it.Next() != expected[i] {
+			t.Fatalf("the reverse iterator differs from the reference at %d", i)
+		}
+	}
+	many := make([]uint32, 0, len(expected))
+	buf := make([]uint32, 1000)
+	for mit := rb.ManyIterator(); ; {
+		n := mit.NextMany(buf)
+		if n == 0 {
+			break
+		}
+		many = append(many, buf[:n]...)
+	}
+	if !equalUint32s(many, expected) {
+		t.Fatal("the many iterator differs from the reference")
+	}
+}
+
+func equalUint32s(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPairAgainstBitset compares the binary operations on a and b to the
+// reference, the many-way aggregations are only checked if thorough is set
+func checkPairAgainstBitset(t *testing.T, a, b *Bitmap, abs, bbs *bitset.BitSet, thorough bool) {
+	and, or := abs.Intersection(bbs), abs.Union(bbs)
+	xor, andNot := abs.SymmetricDifference(bbs), abs.Difference(bbs)
+	type result struct {
+		name     string
+		got      *Bitmap
+		expected *bitset.BitSet
+	}
+	results := []result{
+		{"And", And(a, b), and},
+		{"Or", Or(a, b), or},
+		{"Xor", Xor(a, b), xor},
+		{"AndNot", AndNot(a, b), andNot},
+	}
+	if thorough {
+		results = append(results, []result{
+			{"FastAnd", FastAnd(a, b), and},
+			{"FastOr", FastOr(a, b), or},
+			{"FastXor", FastXor(a, b), xor},
+			{"FastAndNot", FastAndNot(a, b), andNot},
+			{"HeapOr", HeapOr(a, b), or},
+			{"HeapXor", HeapXor(a, b), xor},
+			{"ParOr", ParOr(2, a, b), or},
+			{"ParAnd", ParAnd(2, a, b), and},
+			{"ThresholdOr", ThresholdOr(2, a, b, a), abs.Union(and)},
+		}...)
+	}
+	for _, r := range results {
+		if !sameAsBitset(r.got, r.expected) {
+			t.Fatalf("%s differs from the reference", r.name)
+		}
+	}
+	cards := []struct {
+		name     string
+		got      uint64
+		expected uint
+	}{
+		{"AndCardinality", a.AndCardinality(b), and.Count()},
+		{"OrCardinality", a.OrCardinality(b), or.Count()},
+		{"XorCardinality", a.XorCardinality(b), xor.Count()},
+		{"AndNotCardinality", a.AndNotCardinality(b), andNot.Count()},
+		{"FastAndCardinality", FastAndCardinality(a, b), and.Count()},
+		{"FastOrCardinality", FastOrCardinality(a, b), or.Count()},
+	}
+	for _, c := range cards {
+		if c.got != uint64(c.expected) {
+			t.Fatalf("%s = %d, expected %d", c.name, c.got, c.expected)
+		}
+	}
+	if a.Intersects(b) != (and.Count() > 0) {
+		t.Fatal("Intersects differs from the reference")
+	}
+	if a.IsSubset(b) != (andNot.Count() == 0) {
+		t.Fatal("IsSubset differs from the reference")
+	}
+	if a.Equals(b) != (xor.Count() == 0) {
+		t.Fatal("Equals differs from the reference")
+	}
+}
+
+// FuzzBitmapDifferential interprets the input as a sequence of operations on
+// two bitmaps and checks them against the same operations on bitsets. The
+// first byte selects, one time out of four, the slower checks of the
+// iterators and of the many-way aggregations.
+func FuzzBitmapDifferential(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0, 0, 1, 0, 0, 2, 0, 0, 0, 255, 255, 2, 9, 2, 1, 0, 0, 3, 16})
+	f.Add([]byte{1, 2, 0, 0, 0, 255, 255, 2, 12, 10, 2, 1, 1, 0, 0, 0, 3, 6, 7, 8, 9, 13, 0, 13, 1, 13, 2, 13, 3, 13, 4})
+	f.Add([]byte{0, 4, 2, 10, 0, 100, 1, 12, 11, 1, 3, 0, 0, 200, 1, 14, 7, 1, 15, 0, 0, 0, 0, 3, 255, 255, 2})
+	// values and runs at the end of containers
+	f.Add([]byte{0, 0, 0, 255, 255, 0, 1, 255, 255, 0, 0, 5, 0, 2, 2, 240, 255, 16, 0, 0,
+		128, 1, 255, 255, 13, 4, 13, 5, 15, 0, 255, 255, 9, 13, 1, 134, 3, 240, 255, 16, 0, 0, 11, 13, 5})
+	f.Fuzz(checkBitmapOperations)
+}
+
+// checkBitmapOperations runs the operations encoded by data, see FuzzBitmapDifferential
+func checkBitmapOperations(t *testing.T, data []byte) {
+	r := &fuzzReader{data: data}
+	thorough := r.byte()%4 == 0
+	bitmaps := [2]*Bitmap{NewBitmap(), NewBitmap()}
+	bitsets := [2]*bitset.BitSet{bitset.New(fuzzUniverse), bitset.New(fuzzUniverse)}
+	for steps := 0; !r.done() && steps < 100; steps++ {
+		op := r.byte()
+		i := int(op >> 7)
+		a, abs := bitmaps[i], bitsets[i]
+		b, bbs := bitmaps[1-i], bitsets[1-i]
+		switch op & 0x7f % 17 {
+		case 0:
+			x := r.value()
+			if a.CheckedAdd(x) == abs.Test(uint(x)) {
+				t.Fatalf("CheckedAdd(%d) disagrees with the reference", x)
+			}
+			abs.Set(uint(x))
+		case 1:
+			x := r.value()
+			if a.CheckedRemove(x) != abs.Test(uint(x)) {
+				t.Fatalf("CheckedRemove(%d) disagrees with the reference", x)
+			}
+			abs.Clear(uint(x))
+		case 2:
+			start, end := r.interval()
+			a.AddRange(start, end)
+			bitsetSetRange(abs, start, end)
+		case 3:
+			start, end := r.interval()
+			a.RemoveRange(start, end)
+			bitsetClearRange(abs, start, end)
+		case 4:
+			start, end := r.interval()
+			a.Flip(start, end)
+			bitsetFlipRange(abs, start, end)
+		case 5:
+			vals := make([]uint32, r.byte()%32)
+			for j := range vals {
+				vals[j] = r.value()
+				abs.Set(uint(vals[j]))
+			}
+			a.AddMany(vals)
+		case 6:
+			a.Or(b)
+			abs.InPlaceUnion(bbs)
+		case 7:
+			a.And(b)
+			abs.InPlaceIntersection(bbs)
+		case 8:
+			a.AndNot(b)
+			abs.InPlaceDifference(bbs)
+		case 9:
+			a.Xor(b)
+			abs.InPlaceSymmetricDifference(bbs)
+		case 10:
+			// share the containers, later writes to either bitmap must not affect the other
+			a.SetCopyOnWrite(r.byte()%2 == 0)
+			bitmaps[1-i] = a.Clone()
+			bitsets[1-i] = abs.Clone()
+		case 11:
+			a.RunOptimize()
+		case 12:
+			delta := int64(int16(r.uint16())) * int64(1+r.byte()%4)
+			bitmaps[i] = a.AddOffset(delta)
+			bitmaps[i].RemoveRange(fuzzUniverse, MaxUint32+1)
+			shifted := bitset.New(fuzzUniverse)
+			for v, ok := abs.NextSet(0); ok; v, ok = abs.NextSet(v + 1) {
+				if w := int64(v) + delta; w >= 0 && w < fuzzUniverse {
+					shifted.Set(uint(w))
+				}
+			}
+			bitsets[i] = shifted
+		case 13:
+			// serialization round trips
+			var rb *Bitmap
+			switch r.byte() % 6 {
+			case 0:
+				var buf bytes.Buffer
+				if _, err := a.WriteTo(&buf); err != nil {
+					t.Fatal(err)
+				}
+				if uint64(buf.Len()) != a.GetSerializedSizeInBytes() {
+					t.Fatalf("wrote %d bytes, expected %d", buf.Len(), a.GetSerializedSizeInBytes())
+				}
+				rb = NewBitmap()
+				if _, err := rb.ReadFrom(&buf); err != nil {
+					t.Fatal(err)
+				}
+			case 1:
+				data, err := a.MarshalBinary()
+				if err != nil {
+					t.Fatal(err)
+				}
+				rb = NewBitmap()
+				if _, err := rb.FromBuffer(data); err != nil {
+					t.Fatal(err)
+				}
+			case 2:
+				var buf bytes.Buffer
+				if _, err := a.WriteToMsgpack(&buf); err != nil {
+					t.Fatal(err)
+				}
+				rb = NewBitmap()
+				if _, err := rb.ReadFromMsgpack(&buf); err != nil {
+					t.Fatal(err)
+				}
+			case 3:
+				data, err := a.MarshalJSON()
+				if err != nil {
+					t.Fatal(err)
+				}
+				rb = NewBitmap()
+				if err := rb.UnmarshalJSON(data); err != nil {
+					t.Fatal(err)
+				}
+			case 4:
+				rb = FromRanges(a.Ranges())
+			case 5:
+				data, err := a.MarshalJSONRanges()
+				if err != nil {
+					t.Fatal(err)
+				}
+				rb = NewBitmap()
+				if err := rb.UnmarshalJSON(data); err != nil {
+					t.Fatal(err)
+				}
+			}
+			bitmaps[i] = rb
+		case 14:
+			start, end := r.interval()
+			count := bitsetRangeCount(abs, start, end)
+			if got := a.RangeCardinality(start, end); got != count {
+				t.Fatalf("RangeCardinality(%d, %d) = %d, expected %d", start, end, got, count)
+			}
+			if a.ContainsRange(start, end) != (count == end-start || start >= end) {
+				t.Fatalf("ContainsRange(%d, %d) disagrees with the reference", start, end)
+			}
+			if a.IntersectsRange(start, end) != (count > 0) {
+				t.Fatalf("IntersectsRange(%d, %d) disagrees with the reference", start, end)
+			}
+		case 15:
+			x := r.value()
+			if a.Contains(x) != abs.Test(uint(x)) {
+				t.Fatalf("Contains(%d) disagrees with the reference", x)
+			}
+			if rank := a.Rank(x); rank != bitsetRangeCount(abs, 0, uint64(x)+1) {
+				t.Fatalf("Rank(%d) = %d, expected %d", x, rank, bitsetRangeCount(abs, 0, uint64(x)+1))
+			}
+		case 16:
+			a.Clear()
+			abs.ClearAll()
+		}
+		if bitmaps[i].GetCardinality() != uint64(bitsets[i].Count()) {
+			t.Fatalf("cardinality %d after operation %d, expected %d", bitmaps[i].GetCardinality(), op&0x7f%17, bitsets[i].Count())
+		}
+	}
+	for i := range bitmaps {
+		checkAgainstBitset(t, bitmaps[i], bitsets[i], thorough)
+	}
+	checkPairAgainstBitset(t, bitmaps[0], bitmaps[1], bitsets[0], bitsets[1], thorough)
+	checkPairAgainstBitset(t, bitmaps[1], bitmaps[0], bitsets[1], bitsets[0], thorough)
+}
+
+// fuzzMaxSeedSize skips the large testdata bitmaps: the fuzzing engine
+// minimizes every new input and takes minutes to shrink them
+const fuzzMaxSeedSize = 16 << 10
+
+// FuzzReadFrom checks that deserializing arbitrary input either fails with a
+// format error or gives a valid bitmap that serializes back to the same values
+func FuzzReadFrom(f *testing.F) {
+	for _, pattern := range []string{"testdata/*.bin", "testdata/malformed/*.bin"} {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				f.Fatal(err)
+			}
+			if len(data) > fuzzMaxSeedSize {
+				continue
+			}
+			f.Add(data)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rb := NewBitmap()
+		n, err := rb.ReadFrom(bytes.NewReader(data))
+		fb := NewBitmap()
+		_, ferr := fb.FromBuffer(append([]byte(nil), data...))
+		sb, serr := NewSerializedBitmap(bytes.NewReader(data))
+		if err != nil {
+			if err != ErrInvalidFormat && err != ErrTruncated {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if ferr == nil {
+				t.Fatalf("FromBuffer accepted an input rejected by ReadFrom with %v", err)
+			}
+			return
+		}
+		if n > int64(len(data)) {
+			t.Fatalf("read %d bytes out of %d", n, len(data))
+		}
+		if err := rb.Validate(); err != nil {
+			t.Fatalf("ReadFrom accepted an invalid bitmap: %v", err)
+		}
+		if ferr != nil || !fb.Equals(rb) {
+			t.Fatalf("FromBuffer disagrees with ReadFrom: %v", ferr)
+		}
+		if serr != nil {
+			t.Fatalf("NewSerializedBitmap rejected a valid input: %v", serr)
+		}
+		if sb.GetCardinality() != rb.GetCardinality() {
+			t.Fatalf("SerializedBitmap cardinality %d, expected %d", sb.GetCardinality(), rb.GetCardinality())
+		}
+		if card, err := sb.AndCardinality(rb); err != nil || card != rb.GetCardinality() {
+			t.Fatalf("SerializedBitmap.AndCardinality = %d, %v, expected %d", card, err, rb.GetCardinality())
+		}
+		out, err := rb.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint64(len(out)) != rb.GetSerializedSizeInBytes() {
+			t.Fatalf("wrote %d bytes, expected %d", len(out), rb.GetSerializedSizeInBytes())
+		}
+		back := NewBitmap()
+		if err := back.UnmarshalBinary(out); err != nil || !back.Equals(rb) {
+			t.Fatalf("the round trip changed the bitmap: %v", err)
+		}
+		fb.Add(12345)
+		if !bytes.Equal(out, mustMarshal(t, rb)) {
+			t.Fatal("modifying a bitmap read with FromBuffer changed another bitmap")
+		}
+	})
+}
+
+func mustMarshal(t *testing.T, rb *Bitmap) []byte {
+	out, err := rb.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// fuzzContainer builds a container of the requested type holding the values of bs
+func fuzzContainer(kind byte, bs *bitset.BitSet) container {
+	vals := []uint16{}
+	for i, ok := bs.NextSet(0); ok; i, ok = bs.NextSet(i + 1) {
+		vals = append(vals, uint16(i))
+	}
+	switch kind % 3 {
+	case 0:
+		return &arrayContainer{vals}
+	case 1:
+		bc := newBitmapContainer()
+		for _, v := range vals {
+			bc.iadd(v)
+		}
+		return bc
+	}
+	return newRunContainer16FromVals(true, vals...)
+}
+
+// fuzzContainerSet reads a set of 16-bit values made of single values and ranges
+func fuzzContainerSet(r *fuzzReader) *bitset.BitSet {
+	bs := bitset.New(1 << 16)
+	for n := r.byte() % 16; n > 0; n-- {
+		start := int(r.uint16())
+		switch r.byte() % 3 {
+		case 0:
+			bs.Set(uint(start))
+		case 1:
+			for v := start; v < start+int(r.uint16()) && v < 1<<16; v++ {
+				bs.Set(uint(v))
+			}
+		case 2:
+			// every other value, which suits bitmap containers
+			for v := start; v < start+2*int(r.byte())*64 && v < 1<<16; v += 2 {
+				bs.Set(uint(v))
+			}
+		}
+	}
+	return bs
+}
+
+// checkContainer compares the content of c to the reference, the type of c
+// is not checked since intermediate results may be empty or not minimal
+func checkContainer(t *testing.T, name string, c container, expected *bitset.BitSet) {
+	count := 0
+	prev := -1
+	for it := c.getShortIterator(); it.hasNext(); count++ {
+		v := int(it.next())
+		if v <= prev || !expected.Test(uint(v)) {
+			t.Fatalf("%s gave a %T with unexpected value %d", name, c, v)
+		}
+		prev = v
+	}
+	if count != int(expected.Count()) || c.getCardinality() != count {
+		t.Fatalf("%s gave a %T of cardinality %d holding %d values, expected %d", name, c, c.getCardinality(), count, expected.Count())
+	}
+}
+
+// FuzzContainerPairs runs every pair of container types through the binary
+// operations and negation, comparing the results with bitsets
+func FuzzContainerPairs(f *testing.F) {
+	f.Add([]byte{0, 1, 3, 0, 1, 0, 0, 2, 0, 1, 255, 255, 2, 0, 16, 1, 100, 0, 0, 50})
+	f.Add([]byte{1, 2, 2, 0, 0, 1, 0, 16, 8, 0, 1, 2, 1, 0, 128, 2, 64, 4, 1, 1, 0, 0, 0})
+	f.Add([]byte{2, 0, 1, 255, 255, 0, 1, 1, 0, 0, 2, 255, 0, 2, 0, 1, 255, 255, 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := &fuzzReader{data: data}
+		k1, k2 := r.byte(), r.byte()
+		bs1, bs2 := fuzzContainerSet(r), fuzzContainerSet(r)
+		c1, c2 := fuzzContainer(k1, bs1), fuzzContainer(k2, bs2)
+		checkContainer(t, "construction", c1, bs1)
+		checkContainer(t, "construction", c2, bs2)
+
+		and, or := bs1.Intersection(bs2), bs1.Union(bs2)
+		xor, andNot := bs1.SymmetricDifference(bs2), bs1.Difference(bs2)
+		checkContainer(t, "and", c1.and(c2), and)
+		checkContainer(t, "or", c1.or(c2), or)
+		checkContainer(t, "xor", c1.xor(c2), xor)
+		checkContainer(t, "andNot", c1.andNot(c2), andNot)
+		checkContainer(t, "iand", c1.clone().iand(c2), and)
+		checkContainer(t, "ior", c1.clone().ior(c2), or)
+		checkContainer(t, "iandNot", c1.clone().iandNot(c2), andNot)
+		checkContainer(t, "lazyOR", repairLazy(c1.lazyOR(c2)), or)
+		checkContainer(t, "lazyXOR", repairLazy(c1.lazyXOR(c2)), xor)
+		if c1.andCardinality(c2) != int(and.Count()) {
+			t.Fatalf("andCardinality of %T and %T is %d, expected %d", c1, c2, c1.andCardinality(c2), and.Count())
+		}
+		if c1.intersects(c2) != (and.Count() > 0) {
+			t.Fatalf("intersects of %T and %T disagrees with the reference", c1, c2)
+		}
+		if c1.equals(c2) != (xor.Count() == 0) {
+			t.Fatalf("equals of %T and %T disagrees with the reference", c1, c2)
+		}
+		checkContainer(t, "first operand", c1, bs1)
+		checkContainer(t, "second operand", c2, bs2)
+
+		start := int(r.uint16())
+		end := start + int(r.uint16())
+		if end > 1<<16 {
+			end = 1 << 16
+		}
+		if start >= end {
+			return // the containers reject empty ranges, like Bitmap.Flip skips them
+		}
+		flipped := bs1.Clone()
+		bitsetFlipRange(flipped, uint64(start), uint64(end))
+		checkContainer(t, "not", c1.not(start, end), flipped)
+		checkContainer(t, "inot", c1.clone().inot(start, end), flipped)
+		checkContainer(t, "first operand", c1, bs1)
+	})
+}
+
+// repairLazy computes the cardinality of a bitmap container left by a lazy operation
+func repairLazy(c container) container {
+	if bc, ok := c.(*bitmapContainer); ok && bc.cardinality == invalidCardinality {
+		bc.computeCardinality()
+		if bc.cardinality <= arrayDefaultMaxSize {
+			return bc.toArrayContainer()
+		}
+	}
+	return c
+}
@@ -0,0 +1,236 @@
+package roaring
+
+import (
+	"bytes"
+	"io"
+)
+
+// IntervalSet is a set of uint32 integers stored as a sorted list of disjoint
+// runs [start, last]. Its size only depends on the number of runs, whatever
+// their lengths, which suits sets made of ranges such as IP address or time
+// ranges. It converts to and from Bitmap and uses the same portable
+// serialization. The zero value is an empty set ready to use.
+type IntervalSet struct {
+	rc runContainer32
+}
+
+// NewIntervalSet creates a new empty IntervalSet
+func NewIntervalSet() *IntervalSet {
+	return &IntervalSet{}
+}
+
+// IntervalSetOf generates a new IntervalSet filled with the specified integers
+func IntervalSetOf(dat ...uint32) *IntervalSet {
+	vals := append([]uint32(nil), dat...)
+	return &IntervalSet{rc: *newRunContainer32FromVals(false, vals...)}
+}
+
+// IntervalSetFromBitmap generates a new IntervalSet holding the integers of rb
+func IntervalSetFromBitmap(rb *Bitmap) *IntervalSet {
+	var iv []interval32
+	rb.forEachRange(func(start, last uint32) {
+		iv = append(iv, interval32{start: start, last: last})
+	})
+	return &IntervalSet{rc: *newRunContainer32TakeOwnership(iv)}
+}
+
+// ToBitmap returns a new Bitmap holding the integers of the set
+func (s *IntervalSet) ToBitmap() *Bitmap {
+	return FromRanges(s.Ranges())
+}
+
+// set replaces the runs of s, the cardinality is recomputed when needed
+func (s *IntervalSet) set(rc *runContainer32) {
+	s.rc = runContainer32{iv: rc.iv}
+}
+
+// Clone creates a copy of the IntervalSet
+func (s *IntervalSet) Clone() *IntervalSet {
+	return &IntervalSet{rc: *s.rc.Clone()}
+}
+
+// Add the integer x to the set
+func (s *IntervalSet) Add(x uint32) {
+	s.rc.Add(x)
+	s.rc.card = 0
+}
+
+// AddRange adds the integers in [rangeStart, rangeEnd) to the set
+func (s *IntervalSet) AddRange(rangeStart, rangeEnd uint64) {
+	r, ok := intervalOfRange(rangeStart, rangeEnd)
+	if !ok {
+		return
+	}
+	s.set(s.rc.union(newRunContainer32TakeOwnership([]interval32{r})))
+}
+
+// Remove the integer x from the set
+func (s *IntervalSet) Remove(x uint32) {
+	s.rc.removeKey(x)
+	s.rc.card = 0
+}
+
+// RemoveRange removes the integers in [rangeStart, rangeEnd) from the set
+func (s *IntervalSet) RemoveRange(rangeStart, rangeEnd uint64) {
+	r, ok := intervalOfRange(rangeStart, rangeEnd)
+	if !ok {
+		return
+	}
+	s.set(s.rc.AndNotRunContainer32(newRunContainer32TakeOwnership([]interval32{r})))
+}
+
+// Flip negates the integers in [rangeStart, rangeEnd): those that are in
+// the set are removed and the others are added
+func (s *IntervalSet) Flip(rangeStart, rangeEnd uint64) {
+	r, ok := intervalOfRange(rangeStart, rangeEnd)
+	if !ok {
+		return
+	}
+	if r.start == 0 && r.last == MaxUint32 {
+		s.set(s.rc.invert())
+		return
+	}
+	rc := newRunContainer32TakeOwnership([]interval32{r})
+	s.set(s.rc.AndNotRunContainer32(rc).union(rc.AndNotRunContainer32(&s.rc)))
+}
+
+// intervalOfRange converts [rangeStart, rangeEnd) to a closed interval,
+// clamped to the uint32 range; ok is false if it is empty
+func intervalOfRange(rangeStart, rangeEnd uint64) (r interval32, ok bool) {
+	if rangeEnd > MaxUint32+1 {
+		rangeEnd = MaxUint32 + 1
+	}
+	if rangeStart >= rangeEnd {
+		return r, false
+	}
+	return interval32{start: uint32(rangeStart), last: uint32(rangeEnd - 1)}, true
+}
+
+// Contains returns true if the integer is contained in the set
+func (s *IntervalSet) Contains(x uint32) bool {
+	_, present, _ := s.rc.search(int64(x), nil)
+	return present
+}
+
+// GetCardinality returns the number of integers contained in the set
+func (s *IntervalSet) GetCardinality() uint64 {
+	return uint64(s.rc.cardinality())
+}
+
+// IsEmpty returns true if the set is empty
+func (s *IntervalSet) IsEmpty() bool {
+	return len(s.rc.iv) == 0
+}
+
+// NumRanges returns the number of runs of the set
+func (s *IntervalSet) NumRanges() int {
+	return len(s.rc.iv)
+}
+
+// Ranges returns the runs of the set, in increasing order
+func (s *IntervalSet) Ranges() []Interval {
+	answer := make([]Interval, len(s.rc.iv))
+	for i, iv := range s.rc.iv {
+		answer[i] = Interval{Start: uint64(iv.start), End: uint64(iv.last) + 1}
+	}
+	return answer
+}
+
+// Equals returns true if the two sets contain the same integers
+func (s *IntervalSet) Equals(o *IntervalSet) bool {
+	if len(s.rc.iv) != len(o.rc.iv) {
+		return false
+	}
+	for i, iv := range s.rc.iv {
+		if !iv.equal(o.rc.iv[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Or computes the union between the two sets and stores the result in the current set
+func (s *IntervalSet) Or(o *IntervalSet) {
+	s.set(s.rc.union(&o.rc))
+}
+
+// And computes the intersection between the two sets and stores the result in the current set
+func (s *IntervalSet) And(o *IntervalSet) {
+	s.set(s.rc.intersect(&o.rc))
+}
+
+// AndNot computes the difference between the two sets and stores the result in the current set
+func (s *IntervalSet) AndNot(o *IntervalSet) {
+	if len(o.rc.iv) == 0 {
+		return
+	}
+	s.set(s.rc.AndNotRunContainer32(&o.rc))
+}
+
+// Xor computes the symmetric difference between the two sets and stores the result in the current set
+func (s *IntervalSet) Xor(o *IntervalSet) {
+	s.set(s.rc.AndNotRunContainer32(&o.rc).union(o.rc.AndNotRunContainer32(&s.rc)))
+}
+
+// Intersects checks whether the two sets intersect
+func (s *IntervalSet) Intersects(o *IntervalSet) bool {
+	i, j := 0, 0
+	for i < len(s.rc.iv) && j < len(o.rc.iv) {
+		a, b := s.rc.iv[i], o.rc.iv[j]
+		if a.last < b.start {
+			i++
+		} else if b.last < a.start {
+			j++
+		} else {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteTo writes the set to stream in the portable format of Bitmap.WriteTo
+func (s *IntervalSet) WriteTo(stream io.Writer) (int64, error) {
+	return s.ToBitmap().WriteTo(stream)
+}
+
+// ReadFrom reads a set from stream in the portable format, the input is
+// validated like in Bitmap.ReadFrom. The previous content of the set is discarded.
+func (s *IntervalSet) ReadFrom(stream io.Reader) (int64, error) {
+	rb := NewBitmap()
+	n, err := rb.ReadFrom(stream)
+	if err != nil {
+		return n, err
+	}
+	*s = *IntervalSetFromBitmap(rb)
+	return n, nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface for the set
+func (s *IntervalSet) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := s.WriteTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface for the set
+func (s *IntervalSet) UnmarshalBinary(data []byte) error {
+	_, err := s.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+// String creates a string representation of the set, listing its runs
+func (s *IntervalSet) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, iv := range s.rc.iv {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		buffer.WriteString(iv.String())
+	}
+	buffer.WriteByte('}')
+	return buffer.String()
+}
@@ -0,0 +1,145 @@
+package roaring
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// randomRangesBitmap returns a bitmap made of a few runs, some of them crossing containers
+func randomRangesBitmap(r *rand.Rand) *Bitmap {
+	rb := NewBitmap()
+	for i := r.Intn(10); i > 0; i-- {
+		start := uint64(r.Intn(6 << 16))
+		if r.Intn(8) == 0 {
+			start = MaxUint32 + 1 - uint64(r.Intn(1<<17))
+		}
+		rb.AddRange(start, start+uint64(r.Intn(1<<17)))
+	}
+	for i := r.Intn(20); i > 0; i-- {
+		rb.Add(uint32(r.Intn(6 << 16)))
+	}
+	return rb
+}
+
+func TestIntervalSet(t *testing.T) {
+	Convey("IntervalSet operations should agree with Bitmap", t, func() {
+		r := rand.New(rand.NewSource(2024))
+		for trial := 0; trial < 200; trial++ {
+			a, b := randomRangesBitmap(r), randomRangesBitmap(r)
+			sa, sb := IntervalSetFromBitmap(a), IntervalSetFromBitmap(b)
+			So(sa.GetCardinality(), ShouldEqual, a.GetCardinality())
+			So(sa.IsEmpty(), ShouldEqual, a.IsEmpty())
+			So(sa.Ranges(), ShouldResemble, a.Ranges())
+			So(sa.NumRanges(), ShouldEqual, len(a.Ranges()))
+			So(sa.ToBitmap().Equals(a), ShouldBeTrue)
+			So(sa.Intersects(sb), ShouldEqual, a.Intersects(b))
+			for i := 0; i < 10; i++ {
+				x := uint32(r.Intn(7 << 16))
+				So(sa.Contains(x), ShouldEqual, a.Contains(x))
+			}
+
+			ops := []struct {
+				si func(*IntervalSet, *IntervalSet)
+				bm func(*Bitmap, *Bitmap) *Bitmap
+			}{
+				{(*IntervalSet).Or, Or},
+				{(*IntervalSet).And, And},
+				{(*IntervalSet).AndNot, AndNot},
+				{(*IntervalSet).Xor, Xor},
+			}
+			for _, op := range ops {
+				s := sa.Clone()
+				op.si(s, sb)
+				So(s.ToBitmap().Equals(op.bm(a, b)), ShouldBeTrue)
+				So(sa.ToBitmap().Equals(a), ShouldBeTrue)
+				So(sb.ToBitmap().Equals(b), ShouldBeTrue)
+			}
+
+			start := uint64(r.Intn(6 << 16))
+			end := start + uint64(r.Intn(3<<16))
+			s, rb := sa.Clone(), a.Clone()
+			s.AddRange(start, end)
+			rb.AddRange(start, end)
+			So(s.ToBitmap().Equals(rb), ShouldBeTrue)
+			s, rb = sa.Clone(), a.Clone()
+			s.RemoveRange(start, end)
+			rb.RemoveRange(start, end)
+			So(s.ToBitmap().Equals(rb), ShouldBeTrue)
+			s, rb = sa.Clone(), a.Clone()
+			s.Flip(start, end)
+			rb.Flip(start, end)
+			So(s.ToBitmap().Equals(rb), ShouldBeTrue)
+			So(s.GetCardinality(), ShouldEqual, rb.GetCardinality())
+
+			x := uint32(r.Intn(6 << 16))
+			s, rb = sa.Clone(), a.Clone()
+			s.Add(x)
+			rb.Add(x)
+			So(s.GetCardinality(), ShouldEqual, rb.GetCardinality())
+			s.Remove(x)
+			rb.Remove(x)
+			So(s.GetCardinality(), ShouldEqual, rb.GetCardinality())
+			So(s.ToBitmap().Equals(rb), ShouldBeTrue)
+		}
+	})
+
+	Convey("IntervalSet should handle the whole uint32 range", t, func() {
+		var s IntervalSet
+		So(s.IsEmpty(), ShouldBeTrue)
+		s.Flip(0, MaxUint32+1)
+		So(s.GetCardinality(), ShouldEqual, uint64(MaxUint32)+1)
+		So(s.Contains(MaxUint32), ShouldBeTrue)
+		s.RemoveRange(10, 20)
+		So(s.Ranges(), ShouldResemble, []Interval{{0, 10}, {20, MaxUint32 + 1}})
+		s.Flip(0, 1<<40)
+		So(s.Ranges(), ShouldResemble, []Interval{{10, 20}})
+		So(s.Equals(IntervalSetOf(19, 11, 10, 12, 13, 14, 15, 16, 17, 18)), ShouldBeTrue)
+		So(s.String(), ShouldEqual, "{[10, 19]}")
+		s.AddRange(5, 5)
+		So(s.NumRanges(), ShouldEqual, 1)
+	})
+
+	Convey("IntervalSet should round trip through the portable serialization", t, func() {
+		r := rand.New(rand.NewSource(2025))
+		for trial := 0; trial < 20; trial++ {
+			a := randomRangesBitmap(r)
+			s := IntervalSetFromBitmap(a)
+			var buf bytes.Buffer
+			n, err := s.WriteTo(&buf)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, buf.Len())
+
+			rb := NewBitmap()
+			_, err = rb.ReadFrom(bytes.NewReader(buf.Bytes()))
+			So(err, ShouldBeNil)
+			So(rb.Equals(a), ShouldBeTrue)
+
+			data, err := s.MarshalBinary()
+			So(err, ShouldBeNil)
+			back := IntervalSetOf(1, 2, 3)
+			So(back.UnmarshalBinary(data), ShouldBeNil)
+			So(back.Equals(s), ShouldBeTrue)
+		}
+		So(NewIntervalSet().UnmarshalBinary([]byte{1, 2, 3}), ShouldNotBeNil)
+	})
+
+	Convey("IntervalSet should keep the values at the end of a container", t, func() {
+		s := IntervalSetOf(5, 65535, 1<<17-1, MaxUint32)
+		So(s.ToBitmap().ToArray(), ShouldResemble, []uint32{5, 65535, 1<<17 - 1, MaxUint32})
+		data, err := s.MarshalBinary()
+		So(err, ShouldBeNil)
+		back := NewIntervalSet()
+		So(back.UnmarshalBinary(data), ShouldBeNil)
+		So(back.Equals(s), ShouldBeTrue)
+		var buf bytes.Buffer
+		_, err = s.WriteTo(&buf)
+		So(err, ShouldBeNil)
+		rb := NewBitmap()
+		_, err = rb.ReadFrom(&buf)
+		So(err, ShouldBeNil)
+		So(rb.GetCardinality(), ShouldEqual, 4)
+	})
+}
@@ -0,0 +1,153 @@
+package roaring
+
+import (
+	"runtime"
+	"sync"
+)
+
+// number of key ranges handed out to each worker, more chunks
+// than workers helps balancing when the keys are unevenly filled
+const chunksPerWorker = 4
+
+// keyRangeView returns a roaringArray sharing the containers of ra whose keys
+// are in [start, last]. All its containers are marked as needing copy-on-write
+// so that the view can be aggregated without modifying ra.
+func (ra *roaringArray) keyRangeView(start, last uint16) *roaringArray {
+	begin := ra.getIndex(start)
+	if begin < 0 {
+		begin = -begin - 1
+	}
+	end := begin
+	for end < len(ra.keys) && ra.keys[end] <= last {
+		end++
+	}
+	view := &roaringArray{
+		keys:            ra.keys[begin:end:end],
+		containers:      ra.containers[begin:end:end],
+		needCopyOnWrite: make([]bool, end-begin),
+	}
+	view.markAllAsNeedingCopyOnWrite()
+	return view
+}
+
+// parAggregate splits the keys in [minKey, maxKey] in ranges, applies
+// aggregate to the restriction of bitmaps to each range using parallelism
+// workers, and concatenates the results in key order.
+func parAggregate(parallelism int, minKey, maxKey int, bitmaps []*Bitmap, aggregate func(...*Bitmap) *Bitmap) *Bitmap {
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+	keyRange := maxKey - minKey + 1
+	nchunks := parallelism * chunksPerWorker
+	if nchunks > keyRange {
+		nchunks = keyRange
+	}
+	chunkSize := (keyRange + nchunks - 1) / nchunks
+
+	results := make([]*Bitmap, nchunks)
+	chunks := make(chan int, nchunks)
+	for i := 0; i < nchunks; i++ {
+		chunks <- i
+	}
+	close(chunks)
+
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism && w < nchunks; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range chunks {
+				start := minKey + i*chunkSize
+				if start > maxKey {
+					continue
+				}
+				last := start + chunkSize - 1
+				if last > maxKey {
+					last = maxKey
+				}
+				views := make([]*Bitmap, len(bitmaps))
+				for j, bm := range bitmaps {
+					views[j] = &Bitmap{highlowcontainer: *bm.highlowcontainer.keyRangeView(uint16(start), uint16(last))}
+				}
+				results[i] = aggregate(views...)
+			}
+		}()
+	}
+	wg.Wait()
+
+	answer := NewBitmap()
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		ra := &r.highlowcontainer
+		for i := range ra.keys {
+			answer.highlowcontainer.appendContainer(ra.keys[i], ra.containers[i], ra.needCopyOnWrite[i])
+		}
+	}
+	return answer
+}
+
+// ParOr computes the union (OR) of all provided bitmaps in parallel,
+// where the parameter "parallelism" determines how many workers are to be used
+// (if it is set to 0 or less, a default number of workers is chosen).
+// The key space is split in ranges that are aggregated independently with FastOr.
+func ParOr(parallelism int, bitmaps ...*Bitmap) *Bitmap {
+	if len(bitmaps) == 0 {
+		return NewBitmap()
+	} else if len(bitmaps) == 1 {
+		return bitmaps[0].Clone()
+	}
+	minKey, maxKey := MaxUint16, 0
+	for _, bm := range bitmaps {
+		ra := &bm.highlowcontainer
+		if ra.size() == 0 {
+			continue
+		}
+		if int(ra.keys[0]) < minKey {
+			minKey = int(ra.keys[0])
+		}
+		if int(ra.keys[ra.size()-1]) > maxKey {
+			maxKey = int(ra.keys[ra.size()-1])
+		}
+	}
+	if minKey > maxKey {
+		return NewBitmap()
+	}
+	// FastOr shares containers between its inputs and the answer,
+	// the inputs must copy them before any later modification
+	for _, bm := range bitmaps {
+		bm.highlowcontainer.markAllAsNeedingCopyOnWrite()
+	}
+	return parAggregate(parallelism, minKey, maxKey, bitmaps, FastOr)
+}
+
+// ParAnd computes the intersection (AND) of all provided bitmaps in parallel,
+// where the parameter "parallelism" determines how many workers are to be used
+// (if it is set to 0 or less, a default number of workers is chosen).
+// The key space is split in ranges that are aggregated independently with FastAnd.
+func ParAnd(parallelism int, bitmaps ...*Bitmap) *Bitmap {
+	if len(bitmaps) == 0 {
+		return NewBitmap()
+	} else if len(bitmaps) == 1 {
+		return bitmaps[0].Clone()
+	}
+	// only the keys common to all bitmaps can appear in the answer
+	minKey, maxKey := 0, MaxUint16
+	for _, bm := range bitmaps {
+		ra := &bm.highlowcontainer
+		if ra.size() == 0 {
+			return NewBitmap()
+		}
+		if int(ra.keys[0]) > minKey {
+			minKey = int(ra.keys[0])
+		}
+		if int(ra.keys[ra.size()-1]) < maxKey {
+			maxKey = int(ra.keys[ra.size()-1])
+		}
+	}
+	if minKey > maxKey {
+		return NewBitmap()
+	}
+	return parAggregate(parallelism, minKey, maxKey, bitmaps, FastAnd)
+}
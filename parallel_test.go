@@ -0,0 +1,87 @@
+package roaring
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func randomBitmapsForPar(r *rand.Rand, n int) []*Bitmap {
+	bitmaps := make([]*Bitmap, n)
+	for i := range bitmaps {
+		bm := NewBitmap()
+		for j := 0; j < 2000; j++ {
+			bm.Add(uint32(r.Int31n(200 << 16))) // mostly arrays
+		}
+		for j := 0; j < 20000; j++ {
+			bm.Add(uint32(r.Int31n(1<<16)) + 50<<16) // a bitmap container
+		}
+		start := uint64(r.Int31n(100 << 16))
+		bm.AddRange(start, start+uint64(r.Int31n(3<<16))) // some runs
+		bm.RunOptimize()
+		bitmaps[i] = bm
+	}
+	return bitmaps
+}
+
+func serializedForPar(bm *Bitmap) []byte {
+	var buf bytes.Buffer
+	if _, err := bm.WriteTo(&buf); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParAggregations(t *testing.T) {
+	Convey("ParOr and ParAnd should match FastOr and FastAnd", t, func() {
+		r := rand.New(rand.NewSource(99))
+		bitmaps := randomBitmapsForPar(r, 20)
+		for _, parallelism := range []int{0, 1, 3, 16} {
+			So(serializedForPar(ParOr(parallelism, bitmaps...)), ShouldResemble, serializedForPar(FastOr(bitmaps...)))
+			So(ParAnd(parallelism, bitmaps[:2]...).Equals(FastAnd(bitmaps[:2]...)), ShouldBeTrue)
+			So(ParAnd(parallelism, bitmaps...).Equals(FastAnd(bitmaps...)), ShouldBeTrue)
+		}
+	})
+
+	Convey("ParOr and ParAnd corner cases", t, func() {
+		So(ParOr(4).IsEmpty(), ShouldBeTrue)
+		So(ParAnd(4).IsEmpty(), ShouldBeTrue)
+		So(ParOr(4, NewBitmap(), NewBitmap()).IsEmpty(), ShouldBeTrue)
+		So(ParAnd(4, BitmapOf(1), NewBitmap()).IsEmpty(), ShouldBeTrue)
+		So(ParAnd(4, BitmapOf(1), BitmapOf(1<<20)).IsEmpty(), ShouldBeTrue)
+		So(ParOr(4, BitmapOf(1, MaxUint32)).Equals(BitmapOf(1, MaxUint32)), ShouldBeTrue)
+		So(ParOr(4, BitmapOf(MaxUint32), BitmapOf(MaxUint32-1)).Equals(BitmapOf(MaxUint32-1, MaxUint32)), ShouldBeTrue)
+		So(ParAnd(4, BitmapOf(1, 2, 3), BitmapOf(2, 3, 4)).Equals(BitmapOf(2, 3)), ShouldBeTrue)
+	})
+
+	Convey("modifying the inputs after ParOr should not change the answer", t, func() {
+		a := BitmapOf(1, 2, 3, 1<<20)
+		b := BitmapOf(5<<20, 5<<20+1)
+		answer := ParOr(2, a, b)
+		a.Add(4)
+		b.Remove(5 << 20)
+		So(answer.Equals(BitmapOf(1, 2, 3, 1<<20, 5<<20, 5<<20+1)), ShouldBeTrue)
+		answer.Add(6)
+		So(a.Contains(6), ShouldBeFalse)
+	})
+}
+
+func BenchmarkParOr(b *testing.B) {
+	r := rand.New(rand.NewSource(0))
+	bitmaps := randomBitmapsForPar(r, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ParOr(0, bitmaps...)
+	}
+}
+
+func BenchmarkFastOrForPar(b *testing.B) {
+	r := rand.New(rand.NewSource(0))
+	bitmaps := randomBitmapsForPar(r, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FastOr(bitmaps...)
+	}
+}
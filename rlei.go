@@ -142,8 +142,129 @@ func (rc *runContainer16) fillLeastSignificant16bits(x []uint32, i int, mask uin
 	}
 }
 
-func (rc *runContainer16) getShortIterator() shortIterable {
-	return rc.NewRunIterator16()
+func (rc *runContainer16) getShortIterator() shortPeekable {
+	return &runIterator16{rc: rc}
+}
+
+// runIterator16 walks the values of a runContainer16 in
+// increasing order. Unlike RunIterator16 it can skip ahead
+// without visiting the values in between.
+type runIterator16 struct {
+	rc            *runContainer16
+	curIndex      int
+	curPosInIndex uint16
+}
+
+func (ri *runIterator16) hasNext() bool {
+	return ri.curIndex < len(ri.rc.iv)
+}
+
+func (ri *runIterator16) next() uint16 {
+	iv := ri.rc.iv[ri.curIndex]
+	x := iv.start + ri.curPosInIndex
+	if x == iv.last {
+		ri.curIndex++
+		ri.curPosInIndex = 0
+	} else {
+		ri.curPosInIndex++
+	}
+	return x
+}
+
+func (ri *runIterator16) peekNext() uint16 {
+	return ri.rc.iv[ri.curIndex].start + ri.curPosInIndex
+}
+
+func (ri *runIterator16) advanceIfNeeded(minval uint16) {
+	if !ri.hasNext() || ri.peekNext() >= minval {
+		return
+	}
+	// find the first interval, from the current one on, that ends at or after minval
+	lo, hi := ri.curIndex, len(ri.rc.iv)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if ri.rc.iv[mid].last < minval {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	ri.curIndex = lo
+	ri.curPosInIndex = 0
+	if lo < len(ri.rc.iv) && ri.rc.iv[lo].start < minval {
+		ri.curPosInIndex = minval - ri.rc.iv[lo].start
+	}
+}
+
+func (rc *runContainer16) getManyIterator() manyIterable {
+	return &runManyIterator16{rc: rc}
+}
+
+// runManyIterator16 decodes the values of a runContainer16
+// into a caller-provided buffer.
+type runManyIterator16 struct {
+	rc            *runContainer16
+	curIndex      int
+	curPosInIndex uint16
+}
+
+func (ri *runManyIterator16) nextMany(hs uint32, buf []uint32) int {
+	n := 0
+	for n < len(buf) && ri.curIndex < len(ri.rc.iv) {
+		iv := ri.rc.iv[ri.curIndex]
+		x := iv.start + ri.curPosInIndex
+		for {
+			buf[n] = uint32(x) | hs
+			n++
+			if x == iv.last {
+				ri.curIndex++
+				ri.curPosInIndex = 0
+				break
+			}
+			x++
+			if n == len(buf) {
+				ri.curPosInIndex = x - iv.start
+				break
+			}
+		}
+	}
+	return n
+}
+
+func (rc *runContainer16) getReverseIterator() shortIterable {
+	return &reverseRunIterator16{rc: rc, curIndex: len(rc.iv) - 1}
+}
+
+// reverseRunIterator16 walks the values of a runContainer16
+// from the largest to the smallest.
+type reverseRunIterator16 struct {
+	rc            *runContainer16
+	curIndex      int
+	curPosInIndex uint16
+}
+
+func (ri *reverseRunIterator16) hasNext() bool {
+	return ri.curIndex >= 0
+}
+
+func (ri *reverseRunIterator16) next() uint16 {
+	iv := ri.rc.iv[ri.curIndex]
+	x := iv.last - ri.curPosInIndex
+	if x == iv.start {
+		ri.curIndex--
+		ri.curPosInIndex = 0
+	} else {
+		ri.curPosInIndex++
+	}
+	return x
+}
+
+func (rc *runContainer16) minimum() uint16 {
+	return rc.iv[0].start // assume not empty
+}
+
+func (rc *runContainer16) maximum() uint16 {
+	return rc.iv[len(rc.iv)-1].last // assume not empty
 }
 
 // add the values in the range [firstOfRange, endx). endx
@@ -331,7 +452,8 @@ func (rc *runContainer16) orBitmapContainer(bc *bitmapContainer) container {
 func (rc *runContainer16) orArray(ac *arrayContainer) container {
 	bc1 := newBitmapContainerFromRun(rc)
 	bc2 := ac.toBitmapContainer()
-	return bc1.orBitmap(bc2)
+	// the union may be small enough for an array or a run container
+	return bc1.orBitmap(bc2).toEfficientContainer()
 	/*
 		out := ac.clone()
 		for _, p := range rc.iv {
@@ -355,12 +477,10 @@ func (rc *runContainer16) ior(a container) container {
 	panic("unsupported container type")
 }
 
+// inplaceUnion stores the union of rc and rc2 in rc, merging the runs
+// rather than adding their values one by one.
 func (rc *runContainer16) inplaceUnion(rc2 *runContainer16) container {
-	for _, p := range rc2.iv {
-		for i := p.start; i <= p.last; i++ {
-			rc.Add(i)
-		}
-	}
+	*rc = *rc.union(rc2)
 	return rc
 }
 
@@ -381,7 +501,7 @@ func (rc *runContainer16) iorArray(ac *arrayContainer) container {
 	return rc
 }
 
-// lazyIOR is described (not yet implemented) in
+// lazyIOR is described (not yet implemented lazily) in
 // this nice note from @lemire on
 // https://github.com/RoaringBitmap/roaring/pull/70#issuecomment-263613737
 //
@@ -425,36 +545,49 @@ func (rc *runContainer16) iorArray(ac *arrayContainer) container {
 // trick does is minimize memory allocations.
 //
 func (rc *runContainer16) lazyIOR(a container) container {
-	panic("TODO: runContainer16.lazyIOR not yet implemented")
-
-	/*
-		switch c := a.(type) {
-		case *arrayContainer:
-			return rc.lazyIorArray(c)
-		case *bitmapContainer:
-			return rc.lazyIorBitmap(c)
-		case *runContainer16:
-			return rc.lazyIorRunContainer16(c)
-		}
-		panic("unsupported container type")
-	*/
+	// not lazy at the moment
+	return rc.ior(a)
 }
 
 // lazyOR is described above in lazyIOR.
 func (rc *runContainer16) lazyOR(a container) container {
-	panic("TODO: runContainer16.lazyOR not yet implemented")
-
-	/*
-		switch c := a.(type) {
-		case *arrayContainer:
-			return rc.lazyOrArray(c)
-		case *bitmapContainer:
-			return rc.lazyOrBitmap(c)
-		case *runContainer16:
-			return rc.lazyOrRunContainer16(c)
+	// not lazy at the moment
+	return rc.or(a)
+}
+
+func (rc *runContainer16) addOffset(x uint16) (container, container) {
+	var lo, hi []interval16
+	for _, iv := range rc.iv {
+		start, last := int(iv.start)+int(x), int(iv.last)+int(x)
+		if last <= MaxUint16 {
+			lo = append(lo, interval16{start: uint16(start), last: uint16(last)})
+		} else if start > MaxUint16 {
+			hi = append(hi, interval16{start: uint16(start - 1<<16), last: uint16(last - 1<<16)})
+		} else {
+			lo = append(lo, interval16{start: uint16(start), last: MaxUint16})
+			hi = append(hi, interval16{start: 0, last: uint16(last - 1<<16)})
 		}
-		panic("unsupported container type")
-	*/
+	}
+	var loc, hic container
+	if len(lo) > 0 {
+		loc = newRunContainer16TakeOwnership(lo)
+	}
+	if len(hi) > 0 {
+		hic = newRunContainer16TakeOwnership(hi)
+	}
+	return loc, hic
+}
+
+// lazyIXOR is not done in place, only the xor with a bitmap is lazy
+func (rc *runContainer16) lazyIXOR(a container) container {
+	return rc.lazyXOR(a)
+}
+
+func (rc *runContainer16) lazyXOR(a container) container {
+	if x, ok := a.(*bitmapContainer); ok {
+		return x.lazyXOR(rc)
+	}
+	return rc.xor(a)
 }
 
 func (rc *runContainer16) intersects(a container) bool {
@@ -463,6 +596,52 @@ func (rc *runContainer16) intersects(a container) bool {
 	return isect.getCardinality() > 0
 }
 
+func (rc *runContainer16) andCardinality(a container) int {
+	answer := 0
+	switch x := a.(type) {
+	case *arrayContainer:
+		w := 0
+		for _, v := range x.content {
+			for w < len(rc.iv) && rc.iv[w].last < v {
+				w++
+			}
+			if w == len(rc.iv) {
+				break
+			}
+			if v >= rc.iv[w].start {
+				answer++
+			}
+		}
+		return answer
+	case *bitmapContainer:
+		for _, p := range rc.iv {
+			answer += int(wordCardinalityForBitmapRange(x.bitmap, int(p.start), int(p.last)+1))
+		}
+		return answer
+	case *runContainer16:
+		i, j := 0, 0
+		for i < len(rc.iv) && j < len(x.iv) {
+			lo, hi := rc.iv[i].start, rc.iv[i].last
+			if x.iv[j].start > lo {
+				lo = x.iv[j].start
+			}
+			if x.iv[j].last < hi {
+				hi = x.iv[j].last
+			}
+			if lo <= hi {
+				answer += int(hi-lo) + 1
+			}
+			if rc.iv[i].last < x.iv[j].last {
+				i++
+			} else {
+				j++
+			}
+		}
+		return answer
+	}
+	panic("unsupported container type")
+}
+
 func (rc *runContainer16) xor(a container) container {
 	switch c := a.(type) {
 	case *arrayContainer:
@@ -525,6 +704,45 @@ func (rc *runContainer16) rank(x uint16) int {
 	return int(rnk)
 }
 
+func (rc *runContainer16) getCardinalityInRange(start, end int) int {
+	answer := 0
+	w, already, _ := rc.search(int64(start), nil)
+	if !already {
+		w++
+	}
+	for ; w < int64(len(rc.iv)) && int(rc.iv[w].start) < end; w++ {
+		lo, hi := int(rc.iv[w].start), int(rc.iv[w].last)
+		if lo < start {
+			lo = start
+		}
+		if hi > end-1 {
+			hi = end - 1
+		}
+		answer += hi - lo + 1
+	}
+	return answer
+}
+
+func (rc *runContainer16) containsRange(start, end int) bool {
+	if start >= end {
+		return true
+	}
+	// runs are neither overlapping nor adjacent, the range must fit in a single one
+	w, already, _ := rc.search(int64(start), nil)
+	return already && int(rc.iv[w].last) >= end-1
+}
+
+func (rc *runContainer16) intersectsRange(start, end int) bool {
+	if start >= end {
+		return false
+	}
+	w, already, _ := rc.search(int64(start), nil)
+	if already {
+		return true
+	}
+	return w+1 < int64(len(rc.iv)) && int(rc.iv[w+1].start) < end
+}
+
 func (rc *runContainer16) selectInt(x uint16) int {
 	return rc.selectInt16(x)
 }
@@ -606,6 +824,27 @@ func (rc *runContainer16) xorBitmap(bc *bitmapContainer) container {
 	return rcb.xorBitmap(bc)
 }
 
+// validate checks that the runs are not empty, sorted, and neither overlap nor touch
+func (rc *runContainer16) validate() error {
+	if len(rc.iv) == 0 {
+		return fmt.Errorf("run container is empty")
+	}
+	card := int64(0)
+	for i, p := range rc.iv {
+		if p.last < p.start {
+			return fmt.Errorf("run container has a run ending before its start at position %d", i)
+		}
+		if i > 0 && int(p.start) <= int(rc.iv[i-1].last)+1 {
+			return fmt.Errorf("run container has overlapping or adjacent runs at position %d", i)
+		}
+		card += p.runlen()
+	}
+	if rc.card > 0 && rc.card != card {
+		return fmt.Errorf("run container has a cached cardinality of %d but holds %d values", rc.card, card)
+	}
+	return nil
+}
+
 // convert to bitmap or array *if needed*
 func (rc *runContainer16) toEfficientContainer() container {
 
@@ -620,7 +859,7 @@ func (rc *runContainer16) toEfficientContainer() container {
 	if card <= arrayDefaultMaxSize {
 		ac := newArrayContainer()
 		for i := range rc.iv {
-			ac.iaddRange(int(rc.iv[i].start), int(rc.iv[i].last+1))
+			ac.iaddRange(int(rc.iv[i].start), int(rc.iv[i].last)+1)
 		}
 		return ac
 	}
@@ -1569,3 +1569,26 @@ func TestRle16RandomIaddRangeIremoveRange031(t *testing.T) {
 
 	})
 }
+
+func TestRle16OrArrayAndInplaceUnion062(t *testing.T) {
+	Convey("runContainer16 orArray should return the most efficient container", t, func() {
+		rc := newRunContainer16Range(0, 9)
+		ac := &arrayContainer{[]uint16{5, 20, 30}}
+		answer := rc.orArray(ac)
+		_, isBitmap := answer.(*bitmapContainer)
+		So(isBitmap, ShouldBeFalse)
+		So(answer.getCardinality(), ShouldEqual, 12)
+		So(answer.validate(), ShouldBeNil)
+	})
+
+	Convey("runContainer16 ior with a run container should merge the runs", t, func() {
+		rc := newRunContainer16TakeOwnership([]interval16{{start: 0, last: 99}, {start: 1000, last: 65535}})
+		rc2 := newRunContainer16TakeOwnership([]interval16{{start: 50, last: 2000}})
+		answer := rc.ior(rc2)
+		So(answer, ShouldEqual, rc)
+		So(rc.iv, ShouldResemble, []interval16{{start: 0, last: 65535}})
+		So(rc.getCardinality(), ShouldEqual, 65536)
+		So(rc.validate(), ShouldBeNil)
+		So(rc2.getCardinality(), ShouldEqual, 1951)
+	})
+}
@@ -11,6 +11,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 )
 
@@ -38,31 +39,57 @@ func (rb *Bitmap) FromBase64(str string) (int64, error) {
 	return rb.ReadFrom(buf)
 }
 
-// WriteTo writes a serialized version of this bitmap to stream
+// WriteTo writes a serialized version of this bitmap to stream.
+// The containers are written one at a time, without buffering the whole
+// serialization; the returned count is the number of bytes actually written,
+// also when an error occurs partway.
 func (rb *Bitmap) WriteTo(stream io.Writer) (int64, error) {
 	return rb.highlowcontainer.writeTo(stream)
 }
 
-// WriteTo writes a msgpack2/snappy-streaming compressed serialized
-// version of this bitmap to stream
+// WriteToMsgpack writes a msgpack2/snappy-streaming compressed serialized
+// version of this bitmap to stream, preceded by a format version byte.
+// It returns the number of bytes written.
 func (rb *Bitmap) WriteToMsgpack(stream io.Writer) (int64, error) {
-	return 0, rb.highlowcontainer.writeToMsgpack(stream)
+	return rb.highlowcontainer.writeToMsgpack(stream)
 }
 
-// ReadFrom reads a serialized version of this bitmap from stream
+// ReadFrom reads a serialized version of this bitmap from stream.
+// The input is validated so that it is safe to read untrusted data: it
+// returns ErrInvalidFormat for a malformed input and ErrTruncated for an
+// input that ends too early. The previous content of the bitmap is discarded.
 func (rb *Bitmap) ReadFrom(stream io.Reader) (int64, error) {
 	return rb.highlowcontainer.readFrom(stream)
 }
 
+// FromBuffer creates a bitmap from its serialized version stored in buf,
+// without copying the array and bitmap containers: they keep pointing at
+// buf. The bitmap can be used with every read-only operation; any
+// mutation copies the affected containers first, so buf is never
+// modified. The caller must not modify buf while the bitmap is in use.
+// The input is validated like in ReadFrom.
+// The previous content of the bitmap is discarded.
+func (rb *Bitmap) FromBuffer(buf []byte) (int64, error) {
+	return rb.highlowcontainer.fromBuffer(buf)
+}
+
+// Validate checks the internal invariants of the bitmap and returns an error
+// describing the first violation found, or nil if the bitmap is consistent
+func (rb *Bitmap) Validate() error {
+	return rb.highlowcontainer.validate()
+}
+
 // RunOptimize attempts to further compress the runs of consecutive values found in the bitmap
 func (rb *Bitmap) RunOptimize() {
 	rb.highlowcontainer.runOptimize()
 }
 
 // ReadFromMsgpack reads a msgpack2/snappy-streaming serialized
-// version of this bitmap from stream
+// version of this bitmap from stream. It returns ErrUnsupportedVersion for
+// a stream written with an unknown format version, and the number of bytes
+// read, which can include buffered bytes past the end of the bitmap.
 func (rb *Bitmap) ReadFromMsgpack(stream io.Reader) (int64, error) {
-	return 0, rb.highlowcontainer.readFromMsgpack(stream)
+	return rb.highlowcontainer.readFromMsgpack(stream)
 }
 
 // MarshalBinary implements the encoding.BinaryMarshaler interface for the bitmap
@@ -104,7 +131,7 @@ func New() *Bitmap {
 
 // Clear removes all content from the Bitmap and frees the memory
 func (rb *Bitmap) Clear() {
-	rb.highlowcontainer = *newRoaringArray()
+	rb.highlowcontainer.clear()
 }
 
 // ToArray creates a new slice containing all of the integers stored in the Bitmap in sorted order
@@ -169,10 +196,20 @@ type IntIterable interface {
 	Next() uint32
 }
 
+// PeekableIterator is an IntIterable that can also look at the next value
+// without consuming it, and skip ahead to a given value
+type PeekableIterator interface {
+	IntIterable
+	// PeekNext returns the next value without advancing the iterator
+	PeekNext() uint32
+	// AdvanceIfNeeded moves the iterator forward so that the next value is at least minval
+	AdvanceIfNeeded(minval uint32)
+}
+
 type intIterator struct {
 	pos              int
 	hs               uint32
-	iter             shortIterable
+	iter             shortPeekable
 	highlowcontainer *roaringArray
 }
 
@@ -198,6 +235,27 @@ func (ii *intIterator) Next() uint32 {
 	return x
 }
 
+// PeekNext returns the next value without advancing the iterator
+func (ii *intIterator) PeekNext() uint32 {
+	return uint32(ii.iter.peekNext()) | ii.hs
+}
+
+// AdvanceIfNeeded moves the iterator forward so that the next value is at least minval
+func (ii *intIterator) AdvanceIfNeeded(minval uint32) {
+	to := highbits(minval)
+	if ii.HasNext() && highbits(ii.hs) < to {
+		ii.pos = ii.highlowcontainer.advanceUntil(to, ii.pos)
+		ii.init()
+	}
+	if ii.HasNext() && highbits(ii.hs) == to {
+		ii.iter.advanceIfNeeded(lowbits(minval))
+		if !ii.iter.hasNext() {
+			ii.pos = ii.pos + 1
+			ii.init()
+		}
+	}
+}
+
 func newIntIterator(a *Bitmap) *intIterator {
 	p := new(intIterator)
 	p.pos = 0
@@ -206,6 +264,91 @@ func newIntIterator(a *Bitmap) *intIterator {
 	return p
 }
 
+// ManyIntIterable allows you to iterate over the values in a Bitmap, many at a time
+type ManyIntIterable interface {
+	// NextMany fills buf with the next values and returns how many were written,
+	// zero once the iteration is over
+	NextMany(buf []uint32) int
+}
+
+type manyIntIterator struct {
+	pos              int
+	hs               uint32
+	iter             manyIterable
+	highlowcontainer *roaringArray
+}
+
+func (ii *manyIntIterator) init() {
+	if ii.highlowcontainer.size() > ii.pos {
+		ii.iter = ii.highlowcontainer.getContainerAtIndex(ii.pos).getManyIterator()
+		ii.hs = uint32(ii.highlowcontainer.getKeyAtIndex(ii.pos)) << 16
+	} else {
+		ii.iter = nil
+	}
+}
+
+// NextMany fills buf with the next values and returns how many were written
+func (ii *manyIntIterator) NextMany(buf []uint32) int {
+	n := 0
+	for n < len(buf) {
+		if ii.iter == nil {
+			break
+		}
+		moreN := ii.iter.nextMany(ii.hs, buf[n:])
+		n += moreN
+		if moreN == 0 {
+			ii.pos = ii.pos + 1
+			ii.init()
+		}
+	}
+	return n
+}
+
+func newManyIntIterator(a *Bitmap) *manyIntIterator {
+	p := new(manyIntIterator)
+	p.pos = 0
+	p.highlowcontainer = &a.highlowcontainer
+	p.init()
+	return p
+}
+
+type intReverseIterator struct {
+	pos              int
+	hs               uint32
+	iter             shortIterable
+	highlowcontainer *roaringArray
+}
+
+// HasNext returns true if there are more integers to iterate over
+func (ii *intReverseIterator) HasNext() bool {
+	return ii.pos >= 0
+}
+
+func (ii *intReverseIterator) init() {
+	if ii.pos >= 0 {
+		ii.iter = ii.highlowcontainer.getContainerAtIndex(ii.pos).getReverseIterator()
+		ii.hs = uint32(ii.highlowcontainer.getKeyAtIndex(ii.pos)) << 16
+	}
+}
+
+// Next returns the next integer
+func (ii *intReverseIterator) Next() uint32 {
+	x := uint32(ii.iter.next()) | ii.hs
+	if !ii.iter.hasNext() {
+		ii.pos = ii.pos - 1
+		ii.init()
+	}
+	return x
+}
+
+func newIntReverseIterator(a *Bitmap) *intReverseIterator {
+	p := new(intReverseIterator)
+	p.highlowcontainer = &a.highlowcontainer
+	p.pos = p.highlowcontainer.size() - 1
+	p.init()
+	return p
+}
+
 // String creates a string representation of the Bitmap
 func (rb *Bitmap) String() string {
 	// inspired by https://github.com/fzandona/goroar/
@@ -232,11 +375,38 @@ func (rb *Bitmap) String() string {
 	return buffer.String()
 }
 
-// Iterator creates a new IntIterable to iterate over the integers contained in the bitmap, in sorted order
-func (rb *Bitmap) Iterator() IntIterable {
+// Iterator creates a new PeekableIterator to iterate over the integers contained in the bitmap, in sorted order
+func (rb *Bitmap) Iterator() PeekableIterator {
 	return newIntIterator(rb)
 }
 
+// ManyIterator creates a new ManyIntIterable to iterate over the integers contained in the bitmap, in sorted order
+func (rb *Bitmap) ManyIterator() ManyIntIterable {
+	return newManyIntIterator(rb)
+}
+
+// ReverseIterator creates a new IntIterable to iterate over the integers contained in the bitmap, in decreasing order
+func (rb *Bitmap) ReverseIterator() IntIterable {
+	return newIntReverseIterator(rb)
+}
+
+// Minimum returns the smallest value stored in the bitmap, it panics if the bitmap is empty
+func (rb *Bitmap) Minimum() uint32 {
+	if rb.highlowcontainer.size() == 0 {
+		panic("Minimum called on an empty bitmap")
+	}
+	return uint32(rb.highlowcontainer.containers[0].minimum()) | (uint32(rb.highlowcontainer.keys[0]) << 16)
+}
+
+// Maximum returns the largest value stored in the bitmap, it panics if the bitmap is empty
+func (rb *Bitmap) Maximum() uint32 {
+	last := rb.highlowcontainer.size() - 1
+	if last < 0 {
+		panic("Maximum called on an empty bitmap")
+	}
+	return uint32(rb.highlowcontainer.containers[last].maximum()) | (uint32(rb.highlowcontainer.keys[last]) << 16)
+}
+
 // Clone creates a copy of the Bitmap
 func (rb *Bitmap) Clone() *Bitmap {
 	ptr := new(Bitmap)
@@ -244,6 +414,49 @@ func (rb *Bitmap) Clone() *Bitmap {
 	return ptr
 }
 
+// AddOffset returns a new bitmap holding the values of rb increased by delta,
+// which can be negative; the values that fall outside of [0, MaxUint32] are
+// dropped. When delta is a multiple of 1<<16 only the keys change, otherwise
+// each container is split between two keys and keeps its type, so that runs
+// are preserved.
+func (rb *Bitmap) AddOffset(delta int64) *Bitmap {
+	answer := NewBitmap()
+	if delta <= -(MaxUint32+1) || delta > MaxUint32 {
+		return answer
+	}
+	keyOffset := int(delta >> 16)
+	x := uint16(delta & maxLowBit)
+	ans := &answer.highlowcontainer
+	add := func(key int, c container) {
+		if c == nil || key < 0 || key > MaxUint16 {
+			return
+		}
+		// the high part of the previous container shares its key with the low part of this one
+		if n := ans.size(); n > 0 && int(ans.keys[n-1]) == key {
+			ans.containers[n-1] = ans.containers[n-1].ior(c)
+			return
+		}
+		ans.appendContainer(uint16(key), c, false)
+	}
+	ra := &rb.highlowcontainer
+	for i, c := range ra.containers {
+		key := int(ra.keys[i]) + keyOffset
+		if key+1 < 0 {
+			continue
+		} else if key > MaxUint16 {
+			break
+		}
+		if x == 0 {
+			add(key, c.clone())
+			continue
+		}
+		lo, hi := c.addOffset(x)
+		add(key, lo)
+		add(key+1, hi)
+	}
+	return answer
+}
+
 // Contains returns true if the integer is contained in the bitmap
 func (rb *Bitmap) Contains(x uint32) bool {
 	hb := highbits(x)
@@ -362,6 +575,13 @@ func (rb *Bitmap) IsEmpty() bool {
 
 // GetCardinality returns the number of integers contained in the bitmap
 func (rb *Bitmap) GetCardinality() uint64 {
+	if rb.highlowcontainer.cacheCardinalities {
+		cards := rb.highlowcontainer.cumulativeCardinalities()
+		if len(cards) == 0 {
+			return 0
+		}
+		return cards[len(cards)-1]
+	}
 	size := uint64(0)
 	for _, c := range rb.highlowcontainer.containers {
 		size += uint64(c.getCardinality())
@@ -371,6 +591,20 @@ func (rb *Bitmap) GetCardinality() uint64 {
 
 // Rank returns the number of integers that are smaller or equal to x (Rank(infinity) would be GetCardinality())
 func (rb *Bitmap) Rank(x uint32) uint64 {
+	if rb.highlowcontainer.cacheCardinalities {
+		cards := rb.highlowcontainer.cumulativeCardinalities()
+		i := rb.highlowcontainer.getIndex(highbits(x))
+		size := uint64(0)
+		if i < 0 {
+			i = -i - 1
+		} else {
+			size = uint64(rb.highlowcontainer.getContainerAtIndex(i).rank(lowbits(x)))
+		}
+		if i > 0 {
+			size += cards[i-1]
+		}
+		return size
+	}
 	size := uint64(0)
 	for i := 0; i < rb.highlowcontainer.size(); i++ {
 		key := rb.highlowcontainer.getKeyAtIndex(i)
@@ -388,6 +622,20 @@ func (rb *Bitmap) Rank(x uint32) uint64 {
 
 // Select returns the xth integer in the bitmap
 func (rb *Bitmap) Select(x uint32) (uint32, error) {
+	if rb.highlowcontainer.cacheCardinalities {
+		cards := rb.highlowcontainer.cumulativeCardinalities()
+		if len(cards) == 0 || cards[len(cards)-1] <= uint64(x) {
+			return 0, fmt.Errorf("can't find %dth integer in a bitmap with only %d items", x, rb.GetCardinality())
+		}
+		// first container whose running total goes past x
+		i := sort.Search(len(cards), func(i int) bool { return cards[i] > uint64(x) })
+		remaining := uint64(x)
+		if i > 0 {
+			remaining -= cards[i-1]
+		}
+		key := rb.highlowcontainer.getKeyAtIndex(i)
+		return uint32(key)<<16 + uint32(rb.highlowcontainer.getContainerAtIndex(i).selectInt(uint16(remaining))), nil
+	}
 	if rb.GetCardinality() <= uint64(x) {
 		return 0, fmt.Errorf("can't find %dth integer in a bitmap with only %d items", x, rb.GetCardinality())
 	}
@@ -405,6 +653,117 @@ func (rb *Bitmap) Select(x uint32) (uint32, error) {
 	return 0, fmt.Errorf("can't find %dth integer in a bitmap with only %d items", x, rb.GetCardinality())
 }
 
+// RangeCardinality returns the number of integers in [start, end) that are in the bitmap.
+// Only the containers at the boundaries of the range are inspected value by value.
+func (rb *Bitmap) RangeCardinality(start, end uint64) uint64 {
+	if end > MaxUint32+1 {
+		end = MaxUint32 + 1
+	}
+	if start >= end {
+		return 0
+	}
+	hbStart, lbStart := highbits(uint32(start)), int(lowbits(uint32(start)))
+	hbLast, lbLast := highbits(uint32(end-1)), int(lowbits(uint32(end-1)))
+
+	ra := &rb.highlowcontainer
+	i := ra.getIndex(hbStart)
+	if i < 0 {
+		i = -i - 1
+	}
+	answer := uint64(0)
+	for ; i < ra.size() && ra.getKeyAtIndex(i) <= hbLast; i++ {
+		key := ra.getKeyAtIndex(i)
+		c := ra.getContainerAtIndex(i)
+		if key != hbStart && key != hbLast {
+			answer += uint64(c.getCardinality())
+			continue
+		}
+		first, endx := 0, maxLowBit+1
+		if key == hbStart {
+			first = lbStart
+		}
+		if key == hbLast {
+			endx = lbLast + 1
+		}
+		answer += uint64(c.getCardinalityInRange(first, endx))
+	}
+	return answer
+}
+
+// ContainsRange returns true if all the integers in [start, end) are in the bitmap.
+// An empty range is always contained.
+func (rb *Bitmap) ContainsRange(start, end uint64) bool {
+	if start >= end {
+		return true
+	}
+	if end > MaxUint32+1 {
+		return false
+	}
+	hbStart, lbStart := highbits(uint32(start)), int(lowbits(uint32(start)))
+	hbLast, lbLast := highbits(uint32(end-1)), int(lowbits(uint32(end-1)))
+
+	ra := &rb.highlowcontainer
+	first := ra.getIndex(hbStart)
+	if first < 0 {
+		return false
+	}
+	// keys are strictly increasing, all the keys in between are present
+	// if and only if the last key is at the expected position
+	last := first + int(hbLast-hbStart)
+	if last >= ra.size() || ra.getKeyAtIndex(last) != hbLast {
+		return false
+	}
+	if first == last {
+		return ra.getContainerAtIndex(first).containsRange(lbStart, lbLast+1)
+	}
+	if !ra.getContainerAtIndex(first).containsRange(lbStart, maxLowBit+1) ||
+		!ra.getContainerAtIndex(last).containsRange(0, lbLast+1) {
+		return false
+	}
+	for i := first + 1; i < last; i++ {
+		if ra.getContainerAtIndex(i).getCardinality() != maxLowBit+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// IntersectsRange returns true if at least one integer in [start, end) is in the bitmap.
+func (rb *Bitmap) IntersectsRange(start, end uint64) bool {
+	if end > MaxUint32+1 {
+		end = MaxUint32 + 1
+	}
+	if start >= end {
+		return false
+	}
+	hbStart, lbStart := highbits(uint32(start)), int(lowbits(uint32(start)))
+	hbLast, lbLast := highbits(uint32(end-1)), int(lowbits(uint32(end-1)))
+
+	ra := &rb.highlowcontainer
+	i := ra.getIndex(hbStart)
+	if i < 0 {
+		i = -i - 1
+	}
+	for ; i < ra.size() && ra.getKeyAtIndex(i) <= hbLast; i++ {
+		key := ra.getKeyAtIndex(i)
+		if key != hbStart && key != hbLast {
+			// containers are never empty
+			return true
+		}
+		first, endx := 0, maxLowBit+1
+		if key == hbStart {
+			first = lbStart
+		}
+		if key == hbLast {
+			endx = lbLast + 1
+		}
+		if ra.getContainerAtIndex(i).intersectsRange(first, endx) {
+			return true
+		}
+	}
+	return false
+}
+
 // And computes the intersection between two bitmaps and stores the result in the current bitmap
 func (rb *Bitmap) And(x2 *Bitmap) {
 	pos1 := 0
@@ -484,8 +843,9 @@ main:
 					}
 					s2 = x2.highlowcontainer.getKeyAtIndex(pos2)
 				} else {
-					// TODO: could be faster if we did not have to materialize the container
-					answer += uint64(rb.highlowcontainer.getContainerAtIndex(pos1).or(x2.highlowcontainer.getContainerAtIndex(pos2)).getCardinality())
+					c1 := rb.highlowcontainer.getContainerAtIndex(pos1)
+					c2 := x2.highlowcontainer.getContainerAtIndex(pos2)
+					answer += uint64(c1.getCardinality() + c2.getCardinality() - c1.andCardinality(c2))
 					pos1++
 					pos2++
 					if (pos1 == length1) || (pos2 == length2) {
@@ -525,8 +885,7 @@ main:
 				if s1 == s2 {
 					c1 := rb.highlowcontainer.getContainerAtIndex(pos1)
 					c2 := x2.highlowcontainer.getContainerAtIndex(pos2)
-					diff := c1.and(c2)
-					answer += uint64(diff.getCardinality()) // TODO: could be faster if we did not have to compute diff
+					answer += uint64(c1.andCardinality(c2))
 					pos1++
 					pos2++
 					if (pos1 == length1) || (pos2 == length2) {
@@ -602,6 +961,123 @@ main:
 	return false
 }
 
+// IsSubset returns true if all the integers of the bitmap are in x2, bitmaps are not modified
+func (rb *Bitmap) IsSubset(x2 *Bitmap) bool {
+	pos2 := 0
+	length1 := rb.highlowcontainer.size()
+	length2 := x2.highlowcontainer.size()
+	if length1 > length2 {
+		return false
+	}
+	for pos1 := 0; pos1 < length1; pos1++ {
+		s1 := rb.highlowcontainer.getKeyAtIndex(pos1)
+		if x2.highlowcontainer.getKeyAtIndex(pos2) < s1 {
+			pos2 = x2.highlowcontainer.advanceUntil(s1, pos2)
+		}
+		if pos2 == length2 || x2.highlowcontainer.getKeyAtIndex(pos2) != s1 {
+			return false
+		}
+		c1 := rb.highlowcontainer.getContainerAtIndex(pos1)
+		c2 := x2.highlowcontainer.getContainerAtIndex(pos2)
+		card := c1.getCardinality()
+		if card > c2.getCardinality() || c1.andCardinality(c2) != card {
+			return false
+		}
+		pos2++
+		if pos2 == length2 && pos1+1 < length1 {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSuperset returns true if all the integers of x2 are in the bitmap, bitmaps are not modified
+func (rb *Bitmap) IsSuperset(x2 *Bitmap) bool {
+	return x2.IsSubset(rb)
+}
+
+// overlapCardinalities returns, in a single pass over both bitmaps, the number of
+// integers that are only in rb, only in x2, and in both
+func (rb *Bitmap) overlapCardinalities(x2 *Bitmap) (only1, only2, both uint64) {
+	pos1 := 0
+	pos2 := 0
+	length1 := rb.highlowcontainer.size()
+	length2 := x2.highlowcontainer.size()
+	for pos1 < length1 && pos2 < length2 {
+		s1 := rb.highlowcontainer.getKeyAtIndex(pos1)
+		s2 := x2.highlowcontainer.getKeyAtIndex(pos2)
+		if s1 < s2 {
+			only1 += uint64(rb.highlowcontainer.getContainerAtIndex(pos1).getCardinality())
+			pos1++
+		} else if s1 > s2 {
+			only2 += uint64(x2.highlowcontainer.getContainerAtIndex(pos2).getCardinality())
+			pos2++
+		} else {
+			c1 := rb.highlowcontainer.getContainerAtIndex(pos1)
+			c2 := x2.highlowcontainer.getContainerAtIndex(pos2)
+			and := c1.andCardinality(c2)
+			only1 += uint64(c1.getCardinality() - and)
+			only2 += uint64(c2.getCardinality() - and)
+			both += uint64(and)
+			pos1++
+			pos2++
+		}
+	}
+	for ; pos1 < length1; pos1++ {
+		only1 += uint64(rb.highlowcontainer.getContainerAtIndex(pos1).getCardinality())
+	}
+	for ; pos2 < length2; pos2++ {
+		only2 += uint64(x2.highlowcontainer.getContainerAtIndex(pos2).getCardinality())
+	}
+	return only1, only2, both
+}
+
+// AndNotCardinality returns the cardinality of the difference between two bitmaps, bitmaps are not modified
+func (rb *Bitmap) AndNotCardinality(x2 *Bitmap) uint64 {
+	pos1 := 0
+	pos2 := 0
+	answer := uint64(0)
+	length1 := rb.highlowcontainer.size()
+	length2 := x2.highlowcontainer.size()
+	for ; pos1 < length1; pos1++ {
+		s1 := rb.highlowcontainer.getKeyAtIndex(pos1)
+		c1 := rb.highlowcontainer.getContainerAtIndex(pos1)
+		if pos2 < length2 && x2.highlowcontainer.getKeyAtIndex(pos2) < s1 {
+			pos2 = x2.highlowcontainer.advanceUntil(s1, pos2)
+		}
+		if pos2 < length2 && x2.highlowcontainer.getKeyAtIndex(pos2) == s1 {
+			answer += uint64(c1.getCardinality() - c1.andCardinality(x2.highlowcontainer.getContainerAtIndex(pos2)))
+		} else {
+			answer += uint64(c1.getCardinality())
+		}
+	}
+	return answer
+}
+
+// XorCardinality returns the cardinality of the symmetric difference between two bitmaps, bitmaps are not modified
+func (rb *Bitmap) XorCardinality(x2 *Bitmap) uint64 {
+	only1, only2, _ := rb.overlapCardinalities(x2)
+	return only1 + only2
+}
+
+// HammingDistance returns the number of positions at which the two bitmaps differ,
+// that is the cardinality of their symmetric difference; bitmaps are not modified
+func (rb *Bitmap) HammingDistance(x2 *Bitmap) uint64 {
+	return rb.XorCardinality(x2)
+}
+
+// JaccardIndex returns the Jaccard similarity coefficient of two bitmaps, the
+// cardinality of their intersection divided by the cardinality of their union.
+// It is 1 when both bitmaps are empty; bitmaps are not modified.
+func (rb *Bitmap) JaccardIndex(x2 *Bitmap) float64 {
+	only1, only2, both := rb.overlapCardinalities(x2)
+	union := only1 + only2 + both
+	if union == 0 {
+		return 1
+	}
+	return float64(both) / float64(union)
+}
+
 // Xor computes the symmetric difference between two bitmaps and stores the result in the current bitmap
 func (rb *Bitmap) Xor(x2 *Bitmap) {
 	pos1 := 0
@@ -618,7 +1094,8 @@ func (rb *Bitmap) Xor(x2 *Bitmap) {
 					break
 				}
 			} else if s1 > s2 {
-				c := x2.highlowcontainer.getWritableContainerAtIndex(pos2)
+				// the container of x2 must not be shared: later writes to rb would change x2
+				c := x2.highlowcontainer.getContainerAtIndex(pos2).clone()
 				rb.highlowcontainer.insertNewKeyValueAt(pos1, x2.highlowcontainer.getKeyAtIndex(pos2), c)
 				length1++
 				pos1++
@@ -647,6 +1124,8 @@ func (rb *Bitmap) Xor(x2 *Bitmap) {
 // Or computes the union between two bitmaps and stores the result in the current bitmap
 func (rb *Bitmap) Or(x2 *Bitmap) {
 	results := Or(rb, x2) // Todo: could be computed in-place for reduced memory usage
+	results.highlowcontainer.copyOnWrite = rb.highlowcontainer.copyOnWrite
+	results.highlowcontainer.cacheCardinalities = rb.highlowcontainer.cacheCardinalities
 	rb.highlowcontainer = results.highlowcontainer
 }
 
@@ -920,6 +1399,131 @@ func BitmapOf(dat ...uint32) *Bitmap {
 	return ans
 }
 
+// Interval is the range of integers [Start, End), End can be as large as
+// uint64(0x100000000) so that MaxUint32 can be included
+type Interval struct {
+	Start uint64
+	End   uint64
+}
+
+// forEachRange calls f with the maximal runs [start, last] of consecutive
+// values of the bitmap, in increasing order
+func (rb *Bitmap) forEachRange(f func(start, last uint32)) {
+	pending := false
+	var start, last uint32
+	emit := func(s, l uint32) {
+		if pending && s == last+1 {
+			last = l
+			return
+		}
+		if pending {
+			f(start, last)
+		}
+		start, last, pending = s, l, true
+	}
+	ra := &rb.highlowcontainer
+	for i, c := range ra.containers {
+		hs := uint32(ra.keys[i]) << 16
+		switch c := c.(type) {
+		case *arrayContainer:
+			for j := 0; j < len(c.content); {
+				k := j
+				for k+1 < len(c.content) && c.content[k+1] == c.content[k]+1 {
+					k++
+				}
+				emit(hs|uint32(c.content[j]), hs|uint32(c.content[k]))
+				j = k + 1
+			}
+		case *bitmapContainer:
+			for j := c.NextSetBit(0); j >= 0; {
+				k := c.nextClearBit(j)
+				emit(hs|uint32(j), hs|uint32(k-1))
+				j = c.NextSetBit(k)
+			}
+		case *runContainer16:
+			for _, iv := range c.iv {
+				emit(hs|uint32(iv.start), hs|uint32(iv.last))
+			}
+		}
+	}
+	if pending {
+		f(start, last)
+	}
+}
+
+// Ranges returns the maximal ranges of consecutive integers of the bitmap,
+// in increasing order. Runs that continue across containers are merged.
+func (rb *Bitmap) Ranges() []Interval {
+	var answer []Interval
+	rb.forEachRange(func(start, last uint32) {
+		answer = append(answer, Interval{Start: uint64(start), End: uint64(last) + 1})
+	})
+	return answer
+}
+
+// intervalsByStart sorts intervals by their start
+type intervalsByStart []Interval
+
+func (x intervalsByStart) Len() int           { return len(x) }
+func (x intervalsByStart) Less(i, j int) bool { return x[i].Start < x[j].Start }
+func (x intervalsByStart) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+
+// FromRanges generates a new bitmap holding the union of the given ranges.
+// The ranges may be unsorted and may overlap; ends larger than
+// uint64(0x100000000) are clamped and empty ranges are ignored. The runs of
+// each container are built directly, the containers are then converted to
+// the most compact type.
+func FromRanges(ranges []Interval) *Bitmap {
+	if !sort.IsSorted(intervalsByStart(ranges)) {
+		ranges = append([]Interval(nil), ranges...)
+		sort.Sort(intervalsByStart(ranges))
+	}
+
+	ans := NewBitmap()
+	ra := &ans.highlowcontainer
+	var key uint16
+	var iv []interval16
+	flush := func() {
+		if len(iv) > 0 {
+			ra.appendContainer(key, newRunContainer16TakeOwnership(iv).toEfficientContainer(), false)
+			iv = nil
+		}
+	}
+	covered := uint64(0) // all values below covered that are in the ranges have been added
+	for _, r := range ranges {
+		start, end := r.Start, r.End
+		if end > MaxUint32+1 {
+			end = MaxUint32 + 1
+		}
+		if start < covered {
+			start = covered
+		}
+		for start < end {
+			// split the range at key boundaries
+			last := start | maxLowBit
+			if last >= end {
+				last = end - 1
+			}
+			hb, lbStart, lbLast := highbits(uint32(start)), lowbits(uint32(start)), lowbits(uint32(last))
+			if len(iv) > 0 && hb == key && int(lbStart) == int(iv[len(iv)-1].last)+1 {
+				iv[len(iv)-1].last = lbLast
+			} else {
+				if hb != key {
+					flush()
+					key = hb
+				}
+				iv = append(iv, interval16{start: lbStart, last: lbLast})
+			}
+			start = last + 1
+		}
+		if start > covered {
+			covered = start
+		}
+	}
+	flush()
+	return ans
+}
+
 // Flip negates the bits in the given range (i.e., [rangeStart,rangeEnd)), any integer present in this range and in the bitmap is removed,
 // and any integer present in the range and not in the bitmap is added.
 // The function uses 64-bit parameters even though a Bitmap stores 32-bit values because it is allowed and meaningful to use [0,uint64(0x100000000)) as a range
@@ -992,24 +1596,25 @@ func (rb *Bitmap) AddRange(rangeStart, rangeEnd uint64) {
 	lbLast := uint32(lowbits(uint32(rangeEnd - 1)))
 
 	var max uint32 = maxLowBit
-	for hb := uint16(hbStart); hb <= uint16(hbLast); hb++ {
+	// hb is a uint32 so that the loop terminates when hbLast is MaxUint16
+	for hb := hbStart; hb <= hbLast; hb++ {
 		containerStart := uint32(0)
-		if hb == uint16(hbStart) {
+		if hb == hbStart {
 			containerStart = lbStart
 		}
 		containerLast := max
-		if hb == uint16(hbLast) {
+		if hb == hbLast {
 			containerLast = lbLast
 		}
 
-		i := rb.highlowcontainer.getIndex(hb)
+		i := rb.highlowcontainer.getIndex(uint16(hb))
 
 		if i >= 0 {
 			c := rb.highlowcontainer.getWritableContainerAtIndex(i).iaddRange(int(containerStart), int(containerLast+1))
 			rb.highlowcontainer.setContainerAtIndex(i, c)
 		} else { // *think* the range of ones must never be
 			// empty.
-			rb.highlowcontainer.insertNewKeyValueAt(-i-1, hb, rangeOfOnes(int(containerStart), int(containerLast)))
+			rb.highlowcontainer.insertNewKeyValueAt(-i-1, uint16(hb), rangeOfOnes(int(containerStart), int(containerLast)))
 		}
 	}
 }
@@ -1143,6 +1748,22 @@ func (rb *Bitmap) GetCopyOnWrite() (val bool) {
 	return rb.highlowcontainer.copyOnWrite
 }
 
+// SetCardinalityCache enables or disables caching the cumulative cardinalities
+// of the containers. With the cache, GetCardinality, Rank and Select take
+// logarithmic rather than linear time in the number of containers; the cache is
+// rebuilt lazily on the first such call after a modification. Since these calls
+// may then write to the bitmap, a bitmap with the cache enabled must not be read
+// from several goroutines without synchronization.
+func (rb *Bitmap) SetCardinalityCache(val bool) {
+	rb.highlowcontainer.cacheCardinalities = val
+	rb.highlowcontainer.invalidateCardinalities()
+}
+
+// GetCardinalityCache gets this bitmap's cardinality caching property
+func (rb *Bitmap) GetCardinalityCache() (val bool) {
+	return rb.highlowcontainer.cacheCardinalities
+}
+
 // FlipInt calls Flip after casting the parameters (convenience method)
 func FlipInt(bm *Bitmap, rangeStart, rangeEnd int) *Bitmap {
 	return Flip(bm, uint64(rangeStart), uint64(rangeEnd))
@@ -1188,3 +1809,35 @@ func (bm *Bitmap) Stats() Statistics {
 	}
 	return stats
 }
+
+// ContainerStatistics describes one container of a bitmap
+type ContainerStatistics struct {
+	Key         uint16 // the 16 most significant bits shared by the values of the container
+	Type        string // "array", "bitmap" or "run"
+	Cardinality uint64
+	Runs        uint64 // number of runs of consecutive values
+	Bytes       uint64 // memory usage, as counted by Stats
+}
+
+// ContainerStats returns the statistics of each container of the bitmap, in key order
+func (bm *Bitmap) ContainerStats() []ContainerStatistics {
+	ra := &bm.highlowcontainer
+	stats := make([]ContainerStatistics, len(ra.containers))
+	for i, c := range ra.containers {
+		stats[i] = ContainerStatistics{
+			Key:         ra.keys[i],
+			Cardinality: uint64(c.getCardinality()),
+			Runs:        uint64(c.numberOfRuns()),
+			Bytes:       uint64(c.getSizeInBytes()),
+		}
+		switch c.(type) {
+		case *arrayContainer:
+			stats[i].Type = "array"
+		case *bitmapContainer:
+			stats[i].Type = "bitmap"
+		case *runContainer16:
+			stats[i].Type = "run"
+		}
+	}
+	return stats
+}
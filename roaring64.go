@@ -0,0 +1,595 @@
+package roaring
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Bitmap64 represents a compressed bitmap where you can add 64-bit integers.
+// The most significant 32 bits of each value select a bucket, kept in a
+// sorted slice, and the least significant 32 bits are stored in that
+// bucket's *Bitmap.
+type Bitmap64 struct {
+	keys    []uint32
+	bitmaps []*Bitmap
+}
+
+// NewBitmap64 creates a new empty Bitmap64
+func NewBitmap64() *Bitmap64 {
+	return &Bitmap64{}
+}
+
+// Bitmap64Of generates a new 64-bit bitmap filled with the specified integers
+func Bitmap64Of(dat ...uint64) *Bitmap64 {
+	ans := NewBitmap64()
+	ans.AddMany(dat)
+	return ans
+}
+
+func highbits64(x uint64) uint32 {
+	return uint32(x >> 32)
+}
+
+func lowbits64(x uint64) uint32 {
+	return uint32(x)
+}
+
+// getIndex returns the position of key hb, or -(insertion point)-1 if absent
+func (rb *Bitmap64) getIndex(hb uint32) int {
+	low := 0
+	high := len(rb.keys) - 1
+	for low <= high {
+		middleIndex := low + (high-low)/2
+		middleValue := rb.keys[middleIndex]
+		if middleValue < hb {
+			low = middleIndex + 1
+		} else if middleValue > hb {
+			high = middleIndex - 1
+		} else {
+			return middleIndex
+		}
+	}
+	return -(low + 1)
+}
+
+func (rb *Bitmap64) getBitmap(hb uint32) *Bitmap {
+	i := rb.getIndex(hb)
+	if i < 0 {
+		return nil
+	}
+	return rb.bitmaps[i]
+}
+
+// getOrCreateBitmap returns the bucket for hb, inserting an empty one if needed
+func (rb *Bitmap64) getOrCreateBitmap(hb uint32) *Bitmap {
+	i := rb.getIndex(hb)
+	if i >= 0 {
+		return rb.bitmaps[i]
+	}
+	bm := NewBitmap()
+	rb.insertAt(-i-1, hb, bm)
+	return bm
+}
+
+func (rb *Bitmap64) insertAt(i int, hb uint32, bm *Bitmap) {
+	rb.keys = append(rb.keys, 0)
+	rb.bitmaps = append(rb.bitmaps, nil)
+	copy(rb.keys[i+1:], rb.keys[i:])
+	copy(rb.bitmaps[i+1:], rb.bitmaps[i:])
+	rb.keys[i] = hb
+	rb.bitmaps[i] = bm
+}
+
+func (rb *Bitmap64) removeAtIndex(i int) {
+	copy(rb.keys[i:], rb.keys[i+1:])
+	copy(rb.bitmaps[i:], rb.bitmaps[i+1:])
+	rb.bitmaps[len(rb.bitmaps)-1] = nil
+	rb.keys = rb.keys[:len(rb.keys)-1]
+	rb.bitmaps = rb.bitmaps[:len(rb.bitmaps)-1]
+}
+
+// appendBitmap adds a bucket after all existing ones; hb must be larger than
+// every key already present.
+func (rb *Bitmap64) appendBitmap(hb uint32, bm *Bitmap) {
+	rb.keys = append(rb.keys, hb)
+	rb.bitmaps = append(rb.bitmaps, bm)
+}
+
+// Clear removes all content from the Bitmap64 and frees the memory
+func (rb *Bitmap64) Clear() {
+	rb.keys = nil
+	rb.bitmaps = nil
+}
+
+// Clone creates a copy of the Bitmap64
+func (rb *Bitmap64) Clone() *Bitmap64 {
+	ans := &Bitmap64{
+		keys:    make([]uint32, len(rb.keys)),
+		bitmaps: make([]*Bitmap, len(rb.bitmaps)),
+	}
+	copy(ans.keys, rb.keys)
+	for i, bm := range rb.bitmaps {
+		ans.bitmaps[i] = bm.Clone()
+	}
+	return ans
+}
+
+// Add the integer x to the bitmap
+func (rb *Bitmap64) Add(x uint64) {
+	rb.getOrCreateBitmap(highbits64(x)).Add(lowbits64(x))
+}
+
+// CheckedAdd adds the integer x to the bitmap and return true if it was added (false if the integer was already present)
+func (rb *Bitmap64) CheckedAdd(x uint64) bool {
+	return rb.getOrCreateBitmap(highbits64(x)).CheckedAdd(lowbits64(x))
+}
+
+// AddMany add all of the values in dat
+func (rb *Bitmap64) AddMany(dat []uint64) {
+	if len(dat) == 0 {
+		return
+	}
+	prev := highbits64(dat[0])
+	bm := rb.getOrCreateBitmap(prev)
+	for _, x := range dat {
+		if hb := highbits64(x); hb != prev {
+			bm = rb.getOrCreateBitmap(hb)
+			prev = hb
+		}
+		bm.Add(lowbits64(x))
+	}
+}
+
+// Remove the integer x from the bitmap
+func (rb *Bitmap64) Remove(x uint64) {
+	rb.CheckedRemove(x)
+}
+
+// CheckedRemove removes the integer x from the bitmap and return true if the integer was effectively remove (and false if the integer was not present)
+func (rb *Bitmap64) CheckedRemove(x uint64) bool {
+	i := rb.getIndex(highbits64(x))
+	if i < 0 {
+		return false
+	}
+	removed := rb.bitmaps[i].CheckedRemove(lowbits64(x))
+	if rb.bitmaps[i].IsEmpty() {
+		rb.removeAtIndex(i)
+	}
+	return removed
+}
+
+// Contains returns true if the integer is contained in the bitmap
+func (rb *Bitmap64) Contains(x uint64) bool {
+	bm := rb.getBitmap(highbits64(x))
+	return bm != nil && bm.Contains(lowbits64(x))
+}
+
+// IsEmpty returns true if the Bitmap64 is empty
+func (rb *Bitmap64) IsEmpty() bool {
+	return len(rb.keys) == 0
+}
+
+// GetCardinality returns the number of integers contained in the bitmap
+func (rb *Bitmap64) GetCardinality() uint64 {
+	size := uint64(0)
+	for _, bm := range rb.bitmaps {
+		size += bm.GetCardinality()
+	}
+	return size
+}
+
+// Rank returns the number of integers that are smaller or equal to x
+func (rb *Bitmap64) Rank(x uint64) uint64 {
+	hb := highbits64(x)
+	size := uint64(0)
+	for i, key := range rb.keys {
+		if key > hb {
+			break
+		}
+		if key < hb {
+			size += rb.bitmaps[i].GetCardinality()
+		} else {
+			size += rb.bitmaps[i].Rank(lowbits64(x))
+		}
+	}
+	return size
+}
+
+// Select returns the xth integer in the bitmap
+func (rb *Bitmap64) Select(x uint64) (uint64, error) {
+	remaining := x
+	for i, bm := range rb.bitmaps {
+		card := bm.GetCardinality()
+		if remaining < card {
+			lo, err := bm.Select(uint32(remaining))
+			if err != nil {
+				return 0, err
+			}
+			return uint64(rb.keys[i])<<32 | uint64(lo), nil
+		}
+		remaining -= card
+	}
+	return 0, fmt.Errorf("can't find %dth integer in a bitmap with only %d items", x, rb.GetCardinality())
+}
+
+// AddRange adds the integers in [rangeStart, rangeEnd) to the bitmap.
+func (rb *Bitmap64) AddRange(rangeStart, rangeEnd uint64) {
+	if rangeStart >= rangeEnd {
+		return
+	}
+	hbStart := highbits64(rangeStart)
+	hbLast := highbits64(rangeEnd - 1)
+	for hb := uint64(hbStart); hb <= uint64(hbLast); hb++ {
+		containerStart := uint64(0)
+		if uint32(hb) == hbStart {
+			containerStart = uint64(lowbits64(rangeStart))
+		}
+		containerEnd := uint64(MaxUint32) + 1
+		if uint32(hb) == hbLast {
+			containerEnd = uint64(lowbits64(rangeEnd-1)) + 1
+		}
+		rb.getOrCreateBitmap(uint32(hb)).AddRange(containerStart, containerEnd)
+	}
+}
+
+// RemoveRange removes the integers in [rangeStart, rangeEnd) from the bitmap.
+func (rb *Bitmap64) RemoveRange(rangeStart, rangeEnd uint64) {
+	if rangeStart >= rangeEnd {
+		return
+	}
+	hbStart := highbits64(rangeStart)
+	hbLast := highbits64(rangeEnd - 1)
+	for i := 0; i < len(rb.keys); {
+		hb := rb.keys[i]
+		if hb < hbStart {
+			i++
+			continue
+		}
+		if hb > hbLast {
+			break
+		}
+		containerStart := uint64(0)
+		if hb == hbStart {
+			containerStart = uint64(lowbits64(rangeStart))
+		}
+		containerEnd := uint64(MaxUint32) + 1
+		if hb == hbLast {
+			containerEnd = uint64(lowbits64(rangeEnd-1)) + 1
+		}
+		rb.bitmaps[i].RemoveRange(containerStart, containerEnd)
+		if rb.bitmaps[i].IsEmpty() {
+			rb.removeAtIndex(i)
+		} else {
+			i++
+		}
+	}
+}
+
+// RunOptimize attempts to further compress the runs of consecutive values found in the bitmap
+func (rb *Bitmap64) RunOptimize() {
+	for _, bm := range rb.bitmaps {
+		bm.RunOptimize()
+	}
+}
+
+// Equals returns true if the two bitmaps contain the same integers
+func (rb *Bitmap64) Equals(o interface{}) bool {
+	srb, ok := o.(*Bitmap64)
+	if !ok || len(srb.keys) != len(rb.keys) {
+		return false
+	}
+	for i, key := range rb.keys {
+		if key != srb.keys[i] || !rb.bitmaps[i].Equals(srb.bitmaps[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// And computes the intersection between two bitmaps and stores the result in the current bitmap
+func (rb *Bitmap64) And(x2 *Bitmap64) {
+	pos1 := 0
+	pos2 := 0
+	intersectionsize := 0
+	length1 := len(rb.keys)
+	length2 := len(x2.keys)
+	for pos1 < length1 && pos2 < length2 {
+		s1 := rb.keys[pos1]
+		s2 := x2.keys[pos2]
+		if s1 == s2 {
+			bm := rb.bitmaps[pos1]
+			bm.And(x2.bitmaps[pos2])
+			if !bm.IsEmpty() {
+				rb.keys[intersectionsize] = s1
+				rb.bitmaps[intersectionsize] = bm
+				intersectionsize++
+			}
+			pos1++
+			pos2++
+		} else if s1 < s2 {
+			pos1++
+		} else {
+			pos2++
+		}
+	}
+	for k := intersectionsize; k < length1; k++ {
+		rb.bitmaps[k] = nil
+	}
+	rb.keys = rb.keys[:intersectionsize]
+	rb.bitmaps = rb.bitmaps[:intersectionsize]
+}
+
+// Or computes the union between two bitmaps and stores the result in the current bitmap
+func (rb *Bitmap64) Or(x2 *Bitmap64) {
+	answer := NewBitmap64()
+	pos1 := 0
+	pos2 := 0
+	length1 := len(rb.keys)
+	length2 := len(x2.keys)
+	for pos1 < length1 && pos2 < length2 {
+		s1 := rb.keys[pos1]
+		s2 := x2.keys[pos2]
+		if s1 < s2 {
+			answer.appendBitmap(s1, rb.bitmaps[pos1])
+			pos1++
+		} else if s1 > s2 {
+			answer.appendBitmap(s2, x2.bitmaps[pos2].Clone())
+			pos2++
+		} else {
+			bm := rb.bitmaps[pos1]
+			bm.Or(x2.bitmaps[pos2])
+			answer.appendBitmap(s1, bm)
+			pos1++
+			pos2++
+		}
+	}
+	for ; pos1 < length1; pos1++ {
+		answer.appendBitmap(rb.keys[pos1], rb.bitmaps[pos1])
+	}
+	for ; pos2 < length2; pos2++ {
+		answer.appendBitmap(x2.keys[pos2], x2.bitmaps[pos2].Clone())
+	}
+	*rb = *answer
+}
+
+// Xor computes the symmetric difference between two bitmaps and stores the result in the current bitmap
+func (rb *Bitmap64) Xor(x2 *Bitmap64) {
+	answer := NewBitmap64()
+	pos1 := 0
+	pos2 := 0
+	length1 := len(rb.keys)
+	length2 := len(x2.keys)
+	for pos1 < length1 && pos2 < length2 {
+		s1 := rb.keys[pos1]
+		s2 := x2.keys[pos2]
+		if s1 < s2 {
+			answer.appendBitmap(s1, rb.bitmaps[pos1])
+			pos1++
+		} else if s1 > s2 {
+			answer.appendBitmap(s2, x2.bitmaps[pos2].Clone())
+			pos2++
+		} else {
+			bm := rb.bitmaps[pos1]
+			bm.Xor(x2.bitmaps[pos2])
+			if !bm.IsEmpty() {
+				answer.appendBitmap(s1, bm)
+			}
+			pos1++
+			pos2++
+		}
+	}
+	for ; pos1 < length1; pos1++ {
+		answer.appendBitmap(rb.keys[pos1], rb.bitmaps[pos1])
+	}
+	for ; pos2 < length2; pos2++ {
+		answer.appendBitmap(x2.keys[pos2], x2.bitmaps[pos2].Clone())
+	}
+	*rb = *answer
+}
+
+// AndNot computes the difference between two bitmaps and stores the result in the current bitmap
+func (rb *Bitmap64) AndNot(x2 *Bitmap64) {
+	pos1 := 0
+	pos2 := 0
+	size := 0
+	length1 := len(rb.keys)
+	length2 := len(x2.keys)
+	for pos1 < length1 {
+		s1 := rb.keys[pos1]
+		for pos2 < length2 && x2.keys[pos2] < s1 {
+			pos2++
+		}
+		bm := rb.bitmaps[pos1]
+		if pos2 < length2 && x2.keys[pos2] == s1 {
+			bm.AndNot(x2.bitmaps[pos2])
+		}
+		if !bm.IsEmpty() {
+			rb.keys[size] = s1
+			rb.bitmaps[size] = bm
+			size++
+		}
+		pos1++
+	}
+	for k := size; k < length1; k++ {
+		rb.bitmaps[k] = nil
+	}
+	rb.keys = rb.keys[:size]
+	rb.bitmaps = rb.bitmaps[:size]
+}
+
+// IntIterable64 allows you to iterate over the values in a Bitmap64
+type IntIterable64 interface {
+	HasNext() bool
+	Next() uint64
+}
+
+type intIterator64 struct {
+	pos  int
+	hs   uint64
+	iter IntIterable
+	rb   *Bitmap64
+}
+
+// HasNext returns true if there are more integers to iterate over
+func (ii *intIterator64) HasNext() bool {
+	return ii.pos < len(ii.rb.keys)
+}
+
+func (ii *intIterator64) init() {
+	if len(ii.rb.keys) > ii.pos {
+		ii.iter = ii.rb.bitmaps[ii.pos].Iterator()
+		ii.hs = uint64(ii.rb.keys[ii.pos]) << 32
+	}
+}
+
+// Next returns the next integer
+func (ii *intIterator64) Next() uint64 {
+	x := uint64(ii.iter.Next()) | ii.hs
+	if !ii.iter.HasNext() {
+		ii.pos++
+		ii.init()
+	}
+	return x
+}
+
+// Iterator creates a new IntIterable64 to iterate over the integers contained in the bitmap, in sorted order
+func (rb *Bitmap64) Iterator() IntIterable64 {
+	p := &intIterator64{rb: rb}
+	p.init()
+	return p
+}
+
+// ToArray creates a new slice containing all of the integers stored in the Bitmap64 in sorted order
+func (rb *Bitmap64) ToArray() []uint64 {
+	array := make([]uint64, 0, rb.GetCardinality())
+	for i, bm := range rb.bitmaps {
+		hs := uint64(rb.keys[i]) << 32
+		for _, lo := range bm.ToArray() {
+			array = append(array, hs|uint64(lo))
+		}
+	}
+	return array
+}
+
+// String creates a string representation of the Bitmap64
+func (rb *Bitmap64) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("{")
+	i := rb.Iterator()
+	counter := 0
+	for i.HasNext() {
+		if counter > 0 {
+			buffer.WriteString(",")
+		}
+		counter++
+		// to avoid exhausting the memory
+		if counter > 0x40000 {
+			buffer.WriteString("...")
+			break
+		}
+		buffer.WriteString(strconv.FormatUint(i.Next(), 10))
+	}
+	buffer.WriteString("}")
+	return buffer.String()
+}
+
+// GetSerializedSizeInBytes computes the serialized size in bytes
+// of the Bitmap64. It should correspond to the
+// number of bytes written when invoking WriteTo
+func (rb *Bitmap64) GetSerializedSizeInBytes() uint64 {
+	size := uint64(8)
+	for _, bm := range rb.bitmaps {
+		size += 4 + bm.GetSerializedSizeInBytes()
+	}
+	return size
+}
+
+// WriteTo writes a serialized version of this bitmap to stream, using the
+// portable 64-bit format shared with CRoaring and Java's
+// Roaring64NavigableMap: the number of buckets as a little-endian uint64,
+// then for each bucket its high 32 bits as a little-endian uint32 followed
+// by the bucket serialized as a 32-bit Bitmap.
+// spec: https://github.com/RoaringBitmap/RoaringFormatSpec#extention-for-64-bit-implementations
+func (rb *Bitmap64) WriteTo(stream io.Writer) (int64, error) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(rb.keys)))
+	n, err := stream.Write(buf[:])
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+	for i, bm := range rb.bitmaps {
+		binary.LittleEndian.PutUint32(buf[:4], rb.keys[i])
+		n, err = stream.Write(buf[:4])
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		nb, err := bm.WriteTo(stream)
+		written += nb
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// ReadFrom reads a serialized version of this bitmap from stream, in the
+// format produced by WriteTo. Like Bitmap.ReadFrom it returns ErrInvalidFormat
+// or ErrTruncated for malformed inputs. Empty buckets, which other
+// implementations may write, are skipped but their keys must still be
+// increasing. The previous content of the bitmap is discarded.
+func (rb *Bitmap64) ReadFrom(stream io.Reader) (int64, error) {
+	rb.Clear()
+	var buf [8]byte
+	n, err := io.ReadFull(stream, buf[:])
+	read := int64(n)
+	if err != nil {
+		return read, readError(err)
+	}
+	size := binary.LittleEndian.Uint64(buf[:])
+	// the keys of the empty buckets are not kept in rb.keys
+	var lastKey uint32
+	haveKey := false
+	for i := uint64(0); i < size; i++ {
+		n, err = io.ReadFull(stream, buf[:4])
+		read += int64(n)
+		if err != nil {
+			return read, readError(err)
+		}
+		key := binary.LittleEndian.Uint32(buf[:4])
+		if haveKey && key <= lastKey {
+			return read, ErrInvalidFormat
+		}
+		lastKey, haveKey = key, true
+		bm := NewBitmap()
+		nb, err := bm.ReadFrom(stream)
+		read += nb
+		if err != nil {
+			return read, err
+		}
+		if !bm.IsEmpty() {
+			rb.appendBitmap(key, bm)
+		}
+	}
+	return read, nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface for the bitmap
+func (rb *Bitmap64) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := rb.WriteTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface for the bitmap
+func (rb *Bitmap64) UnmarshalBinary(data []byte) error {
+	_, err := rb.ReadFrom(bytes.NewReader(data))
+	return err
+}
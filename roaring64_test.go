@@ -0,0 +1,262 @@
+package roaring
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBitmap64Basic(t *testing.T) {
+	Convey("Bitmap64 add, remove and contains", t, func() {
+		rb := NewBitmap64()
+		So(rb.IsEmpty(), ShouldBeTrue)
+		vals := []uint64{0, 1, 65535, 65536, 1<<32 - 1, 1 << 32, 1<<32 + 5, 1 << 40, 1<<64 - 1}
+		for _, v := range vals {
+			rb.Add(v)
+		}
+		So(rb.GetCardinality(), ShouldEqual, len(vals))
+		for _, v := range vals {
+			So(rb.Contains(v), ShouldBeTrue)
+		}
+		So(rb.Contains(2), ShouldBeFalse)
+		So(rb.Contains(1<<32+1), ShouldBeFalse)
+		So(rb.ToArray(), ShouldResemble, vals)
+
+		So(rb.CheckedAdd(1<<40), ShouldBeFalse)
+		So(rb.CheckedAdd(1<<40+1), ShouldBeTrue)
+		So(rb.CheckedRemove(1<<40+1), ShouldBeTrue)
+		So(rb.CheckedRemove(1<<40+1), ShouldBeFalse)
+
+		rb.Remove(1 << 40)
+		So(rb.Contains(1<<40), ShouldBeFalse)
+		So(len(rb.keys), ShouldEqual, 3)
+		So(rb.String(), ShouldEqual, "{0,1,65535,65536,4294967295,4294967296,4294967301,18446744073709551615}")
+
+		rb.Clear()
+		So(rb.IsEmpty(), ShouldBeTrue)
+	})
+}
+
+func TestBitmap64RankSelect(t *testing.T) {
+	Convey("Bitmap64 rank and select", t, func() {
+		rb := Bitmap64Of(3, 1<<32, 1<<32+7, 5<<32)
+		So(rb.Rank(0), ShouldEqual, 0)
+		So(rb.Rank(3), ShouldEqual, 1)
+		So(rb.Rank(1<<32), ShouldEqual, 2)
+		So(rb.Rank(1<<32+6), ShouldEqual, 2)
+		So(rb.Rank(1<<32+7), ShouldEqual, 3)
+		So(rb.Rank(4<<32), ShouldEqual, 3)
+		So(rb.Rank(1<<64-1), ShouldEqual, 4)
+		for i, v := range rb.ToArray() {
+			x, err := rb.Select(uint64(i))
+			So(err, ShouldBeNil)
+			So(x, ShouldEqual, v)
+		}
+		_, err := rb.Select(4)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestBitmap64Ranges(t *testing.T) {
+	Convey("Bitmap64 AddRange and RemoveRange across bucket boundaries", t, func() {
+		rb := NewBitmap64()
+		rb.AddRange(1<<32-10, 2<<32+10)
+		So(rb.GetCardinality(), ShouldEqual, 1<<32+20)
+		So(len(rb.keys), ShouldEqual, 3)
+		So(rb.Contains(1<<32-10), ShouldBeTrue)
+		So(rb.Contains(1<<32-11), ShouldBeFalse)
+		So(rb.Contains(2<<32+9), ShouldBeTrue)
+		So(rb.Contains(2<<32+10), ShouldBeFalse)
+
+		rb.RemoveRange(1<<32-5, 2<<32+5)
+		So(rb.GetCardinality(), ShouldEqual, 10)
+		So(len(rb.keys), ShouldEqual, 2)
+		So(rb.Contains(1<<32-6), ShouldBeTrue)
+		So(rb.Contains(1<<32-5), ShouldBeFalse)
+		So(rb.Contains(2<<32+5), ShouldBeTrue)
+
+		rb.AddRange(1<<64-3, 1<<64-1)
+		So(rb.Contains(1<<64-2), ShouldBeTrue)
+		So(rb.Contains(1<<64-1), ShouldBeFalse)
+	})
+}
+
+func sortedUint64s(m map[uint64]bool) []uint64 {
+	answer := make([]uint64, 0, len(m))
+	for k := range m {
+		answer = append(answer, k)
+	}
+	sort.Slice(answer, func(i, j int) bool { return answer[i] < answer[j] })
+	return answer
+}
+
+func randomBitmap64(r *rand.Rand, n int) (*Bitmap64, map[uint64]bool) {
+	rb := NewBitmap64()
+	m := make(map[uint64]bool)
+	for i := 0; i < n; i++ {
+		x := uint64(r.Intn(4))<<32 | uint64(r.Intn(200000))
+		rb.Add(x)
+		m[x] = true
+	}
+	rb.AddRange(3<<32+100, 3<<32+70000)
+	for x := uint64(3<<32 + 100); x < 3<<32+70000; x++ {
+		m[x] = true
+	}
+	return rb, m
+}
+
+func TestBitmap64Aggregations(t *testing.T) {
+	Convey("Bitmap64 And, Or, Xor and AndNot should match a reference set", t, func() {
+		r := rand.New(rand.NewSource(1234))
+		for trial := 0; trial < 5; trial++ {
+			a, ma := randomBitmap64(r, 5000)
+			b, mb := randomBitmap64(r, 5000)
+			b.RemoveRange(3<<32+200, 3<<32+300)
+			for x := uint64(3<<32 + 200); x < 3<<32+300; x++ {
+				delete(mb, x)
+			}
+			b.RunOptimize()
+
+			and, or, xor, andnot := map[uint64]bool{}, map[uint64]bool{}, map[uint64]bool{}, map[uint64]bool{}
+			for k := range ma {
+				or[k] = true
+				if mb[k] {
+					and[k] = true
+				} else {
+					xor[k] = true
+					andnot[k] = true
+				}
+			}
+			for k := range mb {
+				or[k] = true
+				if !ma[k] {
+					xor[k] = true
+				}
+			}
+
+			x := a.Clone()
+			x.And(b)
+			So(x.ToArray(), ShouldResemble, sortedUint64s(and))
+			x = a.Clone()
+			x.Or(b)
+			So(x.ToArray(), ShouldResemble, sortedUint64s(or))
+			x = a.Clone()
+			x.Xor(b)
+			So(x.ToArray(), ShouldResemble, sortedUint64s(xor))
+			x = a.Clone()
+			x.AndNot(b)
+			So(x.ToArray(), ShouldResemble, sortedUint64s(andnot))
+
+			x = a.Clone()
+			x.Xor(a)
+			So(x.IsEmpty(), ShouldBeTrue)
+			x = a.Clone()
+			x.AndNot(a)
+			So(x.IsEmpty(), ShouldBeTrue)
+		}
+	})
+}
+
+func TestBitmap64Iterator(t *testing.T) {
+	Convey("Bitmap64 iterator", t, func() {
+		vals := []uint64{1, 2, 1 << 32, 1<<32 + 1, 7 << 33}
+		rb := Bitmap64Of(vals...)
+		var got []uint64
+		for i := rb.Iterator(); i.HasNext(); {
+			got = append(got, i.Next())
+		}
+		So(got, ShouldResemble, vals)
+		So(NewBitmap64().Iterator().HasNext(), ShouldBeFalse)
+	})
+}
+
+func TestBitmap64Serialization(t *testing.T) {
+	Convey("Bitmap64 serialization round trip", t, func() {
+		r := rand.New(rand.NewSource(42))
+		rb, _ := randomBitmap64(r, 10000)
+		rb.RunOptimize()
+		buf := &bytes.Buffer{}
+		n, err := rb.WriteTo(buf)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, buf.Len())
+		So(rb.GetSerializedSizeInBytes(), ShouldEqual, buf.Len())
+
+		newrb := NewBitmap64()
+		nr, err := newrb.ReadFrom(buf)
+		So(err, ShouldBeNil)
+		So(nr, ShouldEqual, n)
+		So(newrb.Equals(rb), ShouldBeTrue)
+
+		data, err := rb.MarshalBinary()
+		So(err, ShouldBeNil)
+		other := Bitmap64Of(17)
+		So(other.UnmarshalBinary(data), ShouldBeNil)
+		So(other.Equals(rb), ShouldBeTrue)
+	})
+
+	Convey("Bitmap64 serialization uses the portable layout", t, func() {
+		rb := Bitmap64Of(1, 2, 3<<32+5)
+		data, err := rb.MarshalBinary()
+		So(err, ShouldBeNil)
+
+		expected := &bytes.Buffer{}
+		binary.Write(expected, binary.LittleEndian, uint64(2))
+		binary.Write(expected, binary.LittleEndian, uint32(0))
+		BitmapOf(1, 2).WriteTo(expected)
+		binary.Write(expected, binary.LittleEndian, uint32(3))
+		BitmapOf(5).WriteTo(expected)
+		So(data, ShouldResemble, expected.Bytes())
+
+		So(NewBitmap64().UnmarshalBinary(data[:len(data)-1]), ShouldNotBeNil)
+		So(NewBitmap64().UnmarshalBinary(data[:3]), ShouldNotBeNil)
+	})
+}
+
+func TestBitmap64ReadFromErrors(t *testing.T) {
+	Convey("Bitmap64 ReadFrom should return typed errors", t, func() {
+		data, err := Bitmap64Of(1, 2, 3<<32+5).MarshalBinary()
+		So(err, ShouldBeNil)
+		for i := 0; i < len(data); i++ {
+			_, err := NewBitmap64().ReadFrom(bytes.NewReader(data[:i]))
+			So(err, ShouldEqual, ErrTruncated)
+		}
+
+		bad := &bytes.Buffer{}
+		binary.Write(bad, binary.LittleEndian, uint64(2))
+		binary.Write(bad, binary.LittleEndian, uint32(3))
+		BitmapOf(1).WriteTo(bad)
+		binary.Write(bad, binary.LittleEndian, uint32(3))
+		BitmapOf(2).WriteTo(bad)
+		_, err = NewBitmap64().ReadFrom(bad)
+		So(err, ShouldEqual, ErrInvalidFormat)
+	})
+
+	Convey("Bitmap64 ReadFrom should skip empty buckets", t, func() {
+		data := &bytes.Buffer{}
+		binary.Write(data, binary.LittleEndian, uint64(3))
+		binary.Write(data, binary.LittleEndian, uint32(3))
+		NewBitmap().WriteTo(data)
+		binary.Write(data, binary.LittleEndian, uint32(4))
+		BitmapOf(7).WriteTo(data)
+		binary.Write(data, binary.LittleEndian, uint32(5))
+		NewBitmap().WriteTo(data)
+		rb := NewBitmap64()
+		n, err := rb.ReadFrom(bytes.NewReader(data.Bytes()))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, data.Len())
+		So(rb.Equals(Bitmap64Of(4<<32+7)), ShouldBeTrue)
+
+		bad := &bytes.Buffer{}
+		binary.Write(bad, binary.LittleEndian, uint64(2))
+		binary.Write(bad, binary.LittleEndian, uint32(4))
+		NewBitmap().WriteTo(bad)
+		binary.Write(bad, binary.LittleEndian, uint32(3))
+		BitmapOf(7).WriteTo(bad)
+		_, err = NewBitmap64().ReadFrom(bad)
+		So(err, ShouldEqual, ErrInvalidFormat)
+	})
+}
@@ -1,6 +1,7 @@
 package roaring
 
 import (
+	"fmt"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/willf/bitset"
 	"log"
@@ -1805,6 +1806,36 @@ func TestStats(t *testing.T) {
 		rr.Add(4)
 		So(rr.Stats(), ShouldResemble, expectedStats)
 	})
+	Convey("Test ContainerStats with the three container types", t, func() {
+		rr := BitmapOf(2, 3, 4, 10)
+		for i := uint32(0); i < 10000; i++ {
+			rr.Add(1<<16 + 2*i)
+		}
+		rr.AddRange(5<<16, 5<<16+60000)
+		So(rr.ContainerStats(), ShouldResemble, []ContainerStatistics{
+			{Key: 0, Type: "array", Cardinality: 4, Runs: 2, Bytes: 8},
+			{Key: 1, Type: "bitmap", Cardinality: 10000, Runs: 10000, Bytes: 8192},
+			{Key: 5, Type: "run", Cardinality: 60000, Runs: 1, Bytes: 4},
+		})
+		So(NewBitmap().ContainerStats(), ShouldBeEmpty)
+	})
+}
+
+func TestAddRangeLastContainer058(t *testing.T) {
+	Convey("AddRange should stop after the container of key 0xFFFF", t, func() {
+		rb := NewBitmap()
+		rb.AddRange(MaxUint32-10, MaxUint32+1)
+		So(rb.GetCardinality(), ShouldEqual, 11)
+		So(rb.Contains(MaxUint32), ShouldBeTrue)
+		So(rb.Contains(MaxUint32-11), ShouldBeFalse)
+
+		rb = NewBitmap()
+		rb.AddRange(MaxUint32-(1<<16)-4, MaxUint32+1)
+		So(rb.GetCardinality(), ShouldEqual, 1<<16+5)
+		So(rb.highlowcontainer.size(), ShouldEqual, 2)
+		So(rb.Contains(MaxUint32-(1<<16)-4), ShouldBeTrue)
+		So(rb.Contains(MaxUint32-(1<<16)-5), ShouldBeFalse)
+	})
 }
 
 func TestFlipVerySmall(t *testing.T) {
@@ -1816,3 +1847,531 @@ func TestFlipVerySmall(t *testing.T) {
 		So(rbcard, ShouldEqual, 9)
 	})
 }
+
+func TestReverseIterator(t *testing.T) {
+	Convey("ReverseIterator should walk every container type backwards", t, func() {
+		rb := NewBitmap()
+		So(rb.ReverseIterator().HasNext(), ShouldBeFalse)
+
+		for i := uint32(0); i < 100; i += 3 {
+			rb.Add(i) // array
+		}
+		for i := uint32(1 << 16); i < 1<<16+20000; i += 2 {
+			rb.Add(i) // bitmap
+		}
+		rb.AddRange(3<<16+10, 3<<16+5000)
+		rb.AddRange(3<<16+6000, 3<<16+6001)
+		rb.Add(MaxUint32)
+		rb.RunOptimize()
+
+		expected := rb.ToArray()
+		var got []uint32
+		for i := rb.ReverseIterator(); i.HasNext(); {
+			got = append(got, i.Next())
+		}
+		So(len(got), ShouldEqual, len(expected))
+		for i := range got {
+			So(got[i], ShouldEqual, expected[len(expected)-1-i])
+		}
+	})
+}
+
+func TestMinimumMaximum(t *testing.T) {
+	Convey("Minimum and Maximum for every container type", t, func() {
+		rb := BitmapOf(500, 70000)
+		So(rb.Minimum(), ShouldEqual, 500)
+		So(rb.Maximum(), ShouldEqual, 70000)
+
+		rb = NewBitmap()
+		for i := uint32(65); i < 30000; i += 2 {
+			rb.Add(i + 5<<16)
+		}
+		So(rb.Minimum(), ShouldEqual, 5<<16+65)
+		So(rb.Maximum(), ShouldEqual, 5<<16+29999)
+
+		rb = NewBitmap()
+		rb.AddRange(1000, 300000)
+		rb.RunOptimize()
+		So(rb.Minimum(), ShouldEqual, 1000)
+		So(rb.Maximum(), ShouldEqual, 299999)
+
+		rb = BitmapOf(0, MaxUint32)
+		So(rb.Minimum(), ShouldEqual, 0)
+		So(rb.Maximum(), ShouldEqual, MaxUint32)
+
+		So(func() { NewBitmap().Minimum() }, ShouldPanic)
+		So(func() { NewBitmap().Maximum() }, ShouldPanic)
+	})
+}
+
+func TestManyIterator(t *testing.T) {
+	Convey("ManyIterator should return the same values as Iterator", t, func() {
+		rb := NewBitmap()
+		So(rb.ManyIterator().NextMany(make([]uint32, 8)), ShouldEqual, 0)
+
+		for i := uint32(0); i < 1000; i += 3 {
+			rb.Add(i) // array
+		}
+		for i := uint32(1 << 16); i < 1<<16+20000; i += 2 {
+			rb.Add(i) // bitmap
+		}
+		rb.AddRange(3<<16+10, 3<<16+5000)
+		rb.AddRange(3<<16+6000, 3<<16+6001)
+		rb.AddRange(MaxUint32-300, MaxUint32)
+		rb.Add(MaxUint32)
+		rb.RunOptimize()
+		expected := rb.ToArray()
+
+		for _, size := range []int{1, 7, 64, 256, 5000, 100000} {
+			buf := make([]uint32, size)
+			var got []uint32
+			it := rb.ManyIterator()
+			for n := it.NextMany(buf); n != 0; n = it.NextMany(buf) {
+				So(n == size || len(got)+n == len(expected), ShouldBeTrue)
+				got = append(got, buf[:n]...)
+			}
+			So(got, ShouldResemble, expected)
+			So(it.NextMany(buf), ShouldEqual, 0)
+		}
+	})
+}
+
+func TestPeekableIterator(t *testing.T) {
+	Convey("PeekNext and AdvanceIfNeeded should agree with a sorted array", t, func() {
+		r := rand.New(rand.NewSource(7))
+		rb := NewBitmap()
+		for i := 0; i < 3000; i++ {
+			rb.Add(uint32(r.Int31n(1 << 18))) // arrays
+		}
+		for i := 0; i < 40000; i++ {
+			rb.Add(5<<16 + uint32(r.Int31n(1<<16))) // bitmap
+		}
+		for i := uint32(0); i < 50; i++ {
+			rb.AddRange(uint64(9<<16+1000*i), uint64(9<<16+1000*i+300)) // runs
+		}
+		rb.Add(MaxUint32)
+		rb.RunOptimize()
+		expected := rb.ToArray()
+
+		it := rb.Iterator()
+		So(it.PeekNext(), ShouldEqual, expected[0])
+		So(it.Next(), ShouldEqual, expected[0])
+		pos := 1
+		for trial := 0; trial < 2000 && it.HasNext(); trial++ {
+			minval := expected[pos] + uint32(r.Int31n(1<<(uint(trial%20))))
+			if trial%7 == 0 {
+				minval = expected[pos] - 1 // should not move backwards
+			}
+			it.AdvanceIfNeeded(minval)
+			for pos < len(expected) && expected[pos] < minval {
+				pos++
+			}
+			if pos == len(expected) {
+				So(it.HasNext(), ShouldBeFalse)
+				break
+			}
+			So(it.HasNext(), ShouldBeTrue)
+			So(it.PeekNext(), ShouldEqual, expected[pos])
+			So(it.Next(), ShouldEqual, expected[pos])
+			pos++
+			So(it.HasNext(), ShouldEqual, pos < len(expected))
+		}
+
+		it = rb.Iterator()
+		it.AdvanceIfNeeded(MaxUint32)
+		So(it.Next(), ShouldEqual, MaxUint32)
+		So(it.HasNext(), ShouldBeFalse)
+
+		it = NewBitmap().Iterator()
+		it.AdvanceIfNeeded(10)
+		So(it.HasNext(), ShouldBeFalse)
+	})
+}
+
+func TestCopyOnWriteCloneIndependence(t *testing.T) {
+	Convey("copy-on-write clones should not share their key and container slices", t, func() {
+		rb := BitmapOf(1, 1<<16, 3<<16)
+		rb.SetCopyOnWrite(true)
+		clone := rb.Clone()
+
+		rb.Add(2 << 16) // inserts a key in the middle
+		rb.Add(2)       // modifies a shared container
+		So(clone.ToArray(), ShouldResemble, []uint32{1, 1 << 16, 3 << 16})
+
+		clone.Remove(1)
+		clone.Add(5 << 16)
+		So(rb.ToArray(), ShouldResemble, []uint32{1, 2, 1 << 16, 2 << 16, 3 << 16})
+		So(clone.ToArray(), ShouldResemble, []uint32{1 << 16, 3 << 16, 5 << 16})
+	})
+}
+
+func randomBitmapForValidate(r *rand.Rand) *Bitmap {
+	rb := NewBitmap()
+	for i := 0; i < 1+r.Intn(5); i++ {
+		key := uint32(r.Intn(8)) << 16
+		switch r.Intn(3) {
+		case 0:
+			for j := 0; j < r.Intn(100); j++ {
+				rb.Add(key + uint32(r.Intn(1<<16)))
+			}
+		case 1:
+			for j := 0; j < 5000+r.Intn(20000); j++ {
+				rb.Add(key + uint32(r.Intn(1<<16)))
+			}
+		case 2:
+			start := key + uint32(r.Intn(1<<16))
+			rb.AddRange(uint64(start), uint64(start)+uint64(r.Intn(100000)))
+		}
+	}
+	if r.Intn(2) == 0 {
+		rb.RunOptimize()
+	}
+	return rb
+}
+
+func TestValidate(t *testing.T) {
+	Convey("bitmaps built by the public operations should be valid", t, func() {
+		r := rand.New(rand.NewSource(2017))
+		for trial := 0; trial < 200; trial++ {
+			a := randomBitmapForValidate(r)
+			b := randomBitmapForValidate(r)
+			c := randomBitmapForValidate(r)
+			So(a.Validate(), ShouldBeNil)
+			for i, x := range []*Bitmap{And(a, b), Or(a, b), Xor(a, b), AndNot(a, b),
+				FastOr(a, b, c), FastAnd(a, b, c), HeapOr(a, b, c), HeapXor(a, b, c), ParOr(2, a, b, c)} {
+				So(fmt.Sprint(i, x.Validate()), ShouldEqual, fmt.Sprint(i, nil))
+			}
+			x := a.Clone()
+			x.Or(b)
+			So(x.Validate(), ShouldBeNil)
+			x.AndNot(c)
+			So(x.Validate(), ShouldBeNil)
+			x.Xor(b)
+			So(x.Validate(), ShouldBeNil)
+			x.And(c)
+			So(x.Validate(), ShouldBeNil)
+			start := uint64(r.Intn(8 << 16))
+			x = Flip(a, start, start+uint64(r.Intn(200000)))
+			So(x.Validate(), ShouldBeNil)
+			x.RemoveRange(start, start+uint64(r.Intn(200000)))
+			So(x.Validate(), ShouldBeNil)
+		}
+	})
+
+	Convey("Validate should detect broken invariants", t, func() {
+		rb := BitmapOf(1, 2, 3, 1<<16)
+		rb.highlowcontainer.keys[1] = 0
+		So(rb.Validate(), ShouldNotBeNil)
+
+		rb = BitmapOf(1, 2, 3)
+		rb.highlowcontainer.containers[0].(*arrayContainer).content[1] = 1
+		So(rb.Validate(), ShouldNotBeNil)
+
+		rb = BitmapOf(1)
+		rb.highlowcontainer.containers[0] = newArrayContainer()
+		So(rb.Validate(), ShouldNotBeNil)
+
+		rb = NewBitmap()
+		for i := uint32(0); i < 10000; i += 2 {
+			rb.Add(i)
+		}
+		bc := rb.highlowcontainer.containers[0].(*bitmapContainer)
+		So(rb.Validate(), ShouldBeNil)
+		bc.cardinality++
+		So(rb.Validate(), ShouldNotBeNil)
+		bc.cardinality--
+		for i := 0; i < 100; i++ {
+			bc.bitmap[i] = 0
+		}
+		bc.computeCardinality()
+		So(rb.Validate(), ShouldNotBeNil)
+
+		rb = NewBitmap()
+		rb.AddRange(0, 100)
+		rb.AddRange(200, 300)
+		rb.RunOptimize()
+		rc := rb.highlowcontainer.containers[0].(*runContainer16)
+		So(rb.Validate(), ShouldBeNil)
+		rc.iv[1].start = 101
+		So(rb.Validate(), ShouldNotBeNil)
+		rc.iv[1].start = 50
+		So(rb.Validate(), ShouldNotBeNil)
+	})
+}
+
+func TestCardinalityCache(t *testing.T) {
+	Convey("Rank, Select and GetCardinality should agree with and without the cache", t, func() {
+		r := rand.New(rand.NewSource(2018))
+		for trial := 0; trial < 20; trial++ {
+			cached := randomBitmapForValidate(r)
+			cached.SetCardinalityCache(true)
+			So(cached.GetCardinalityCache(), ShouldBeTrue)
+			for step := 0; step < 30; step++ {
+				x := uint32(r.Intn(9 << 16))
+				switch r.Intn(6) {
+				case 0:
+					cached.Add(x)
+				case 1:
+					cached.Remove(x)
+				case 2:
+					cached.AddRange(uint64(x), uint64(x)+uint64(r.Intn(70000)))
+				case 3:
+					cached.RemoveRange(uint64(x), uint64(x)+uint64(r.Intn(70000)))
+				case 4:
+					cached.Or(randomBitmapForValidate(r))
+				case 5:
+					cached.AndNot(randomBitmapForValidate(r))
+				}
+				plain := cached.Clone()
+				plain.SetCardinalityCache(false)
+				So(cached.GetCardinality(), ShouldEqual, plain.GetCardinality())
+				for i := 0; i < 20; i++ {
+					y := uint32(r.Intn(10 << 16))
+					So(cached.Rank(y), ShouldEqual, plain.Rank(y))
+					if card := plain.GetCardinality(); card > 0 {
+						k := uint32(r.Int63n(int64(card)))
+						v1, err1 := cached.Select(k)
+						v2, err2 := plain.Select(k)
+						So(err1, ShouldBeNil)
+						So(err2, ShouldBeNil)
+						So(v1, ShouldEqual, v2)
+					}
+				}
+				_, err := cached.Select(uint32(plain.GetCardinality()))
+				So(err, ShouldNotBeNil)
+			}
+		}
+	})
+
+	Convey("the cache should survive clearing and be empty-safe", t, func() {
+		rb := BitmapOf(1, 2, 3)
+		rb.SetCardinalityCache(true)
+		So(rb.Rank(2), ShouldEqual, 2)
+		rb.Clear()
+		So(rb.GetCardinalityCache(), ShouldBeTrue)
+		So(rb.GetCardinality(), ShouldEqual, 0)
+		So(rb.Rank(2), ShouldEqual, 0)
+		_, err := rb.Select(0)
+		So(err, ShouldNotBeNil)
+		rb.Add(1 << 20)
+		So(rb.GetCardinality(), ShouldEqual, 1)
+		v, err := rb.Select(0)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, 1<<20)
+	})
+}
+
+func TestRangeQueries(t *testing.T) {
+	Convey("RangeCardinality, ContainsRange and IntersectsRange should agree with a scan", t, func() {
+		r := rand.New(rand.NewSource(2019))
+		for trial := 0; trial < 100; trial++ {
+			rb := randomBitmapForValidate(r)
+			values := rb.ToArray()
+			for i := 0; i < 50; i++ {
+				var start, end uint64
+				switch r.Intn(3) {
+				case 0:
+					start = uint64(r.Intn(9 << 16))
+					end = start + uint64(r.Intn(3<<16))
+				case 1:
+					// boundaries aligned on containers
+					start = uint64(r.Intn(9)) << 16
+					end = uint64(r.Intn(10)) << 16
+				case 2:
+					// around an existing run of values
+					if len(values) == 0 {
+						continue
+					}
+					start = uint64(values[r.Intn(len(values))])
+					end = start + uint64(r.Intn(100))
+				}
+				count := uint64(0)
+				for _, v := range values {
+					if uint64(v) >= start && uint64(v) < end {
+						count++
+					}
+				}
+				width := uint64(0)
+				if end > start {
+					width = end - start
+				}
+				So(rb.RangeCardinality(start, end), ShouldEqual, count)
+				So(rb.ContainsRange(start, end), ShouldEqual, count == width)
+				So(rb.IntersectsRange(start, end), ShouldEqual, count > 0)
+			}
+		}
+	})
+
+	Convey("range queries at the edges of the integer range", t, func() {
+		rb := NewBitmap()
+		rb.AddRange(MaxUint32-10, MaxUint32+1)
+		So(rb.RangeCardinality(0, MaxUint32+1), ShouldEqual, 11)
+		So(rb.RangeCardinality(0, 1<<40), ShouldEqual, 11)
+		So(rb.ContainsRange(MaxUint32-10, MaxUint32+1), ShouldBeTrue)
+		So(rb.ContainsRange(MaxUint32-10, MaxUint32+2), ShouldBeFalse)
+		So(rb.ContainsRange(5, 5), ShouldBeTrue)
+		So(rb.IntersectsRange(5, 5), ShouldBeFalse)
+		So(rb.IntersectsRange(MaxUint32, 1<<40), ShouldBeTrue)
+		So(rb.IntersectsRange(0, MaxUint32-10), ShouldBeFalse)
+
+		full := NewBitmap()
+		full.AddRange(100, 5<<16+100)
+		So(full.ContainsRange(100, 5<<16+100), ShouldBeTrue)
+		So(full.ContainsRange(99, 5<<16+100), ShouldBeFalse)
+		full.Remove(3 << 16)
+		So(full.ContainsRange(100, 5<<16+100), ShouldBeFalse)
+		So(full.RangeCardinality(100, 5<<16+100), ShouldEqual, 5<<16-1)
+	})
+}
+
+func TestSetMetrics(t *testing.T) {
+	Convey("subset and cardinality metrics should agree with the materialized operations", t, func() {
+		r := rand.New(rand.NewSource(2020))
+		for trial := 0; trial < 200; trial++ {
+			a := randomBitmapForValidate(r)
+			b := randomBitmapForValidate(r)
+			if r.Intn(3) == 0 {
+				// make sure some pairs are nested
+				b.Or(a)
+			}
+			andCard := And(a, b).GetCardinality()
+			orCard := Or(a, b).GetCardinality()
+			So(a.AndCardinality(b), ShouldEqual, andCard)
+			So(a.OrCardinality(b), ShouldEqual, orCard)
+			So(a.AndNotCardinality(b), ShouldEqual, AndNot(a, b).GetCardinality())
+			So(b.AndNotCardinality(a), ShouldEqual, AndNot(b, a).GetCardinality())
+			So(a.XorCardinality(b), ShouldEqual, Xor(a, b).GetCardinality())
+			So(a.HammingDistance(b), ShouldEqual, a.XorCardinality(b))
+			So(a.JaccardIndex(b), ShouldEqual, float64(andCard)/float64(orCard))
+			So(a.IsSubset(b), ShouldEqual, andCard == a.GetCardinality())
+			So(b.IsSubset(a), ShouldEqual, andCard == b.GetCardinality())
+			So(a.IsSuperset(b), ShouldEqual, b.IsSubset(a))
+			So(a.IsSubset(a), ShouldBeTrue)
+		}
+	})
+
+	Convey("metrics with empty bitmaps", t, func() {
+		empty := NewBitmap()
+		rb := BitmapOf(1, 2, 1<<20)
+		So(empty.IsSubset(rb), ShouldBeTrue)
+		So(rb.IsSubset(empty), ShouldBeFalse)
+		So(rb.IsSuperset(empty), ShouldBeTrue)
+		So(empty.JaccardIndex(NewBitmap()), ShouldEqual, 1)
+		So(rb.JaccardIndex(empty), ShouldEqual, 0)
+		So(rb.XorCardinality(empty), ShouldEqual, 3)
+		So(rb.AndNotCardinality(empty), ShouldEqual, 3)
+		So(empty.AndNotCardinality(rb), ShouldEqual, 0)
+		So(BitmapOf(1, 1<<20).IsSubset(BitmapOf(1, 2)), ShouldBeFalse)
+		So(BitmapOf(1, 1<<20).IsSubset(BitmapOf(1, 2, 1<<20, 1<<21)), ShouldBeTrue)
+	})
+}
+
+func TestAddOffset(t *testing.T) {
+	Convey("AddOffset should agree with adding the shifted values one at a time", t, func() {
+		r := rand.New(rand.NewSource(2021))
+		deltas := []int64{0, 1, -1, 1 << 16, -(1 << 16), 3<<16 + 7, 1000, 65535, -65535, -70000,
+			MaxUint32 - 5<<16, MaxUint32 - 3<<16 + 12345, MaxUint32, -MaxUint32, MaxUint32 + 1, -(MaxUint32 + 1)}
+		for trial := 0; trial < 40; trial++ {
+			rb := randomBitmapForValidate(r)
+			for _, delta := range append(deltas, r.Int63n(1<<33)-1<<32) {
+				expected := NewBitmap()
+				for it := rb.Iterator(); it.HasNext(); {
+					if v := int64(it.Next()) + delta; v >= 0 && v <= MaxUint32 {
+						expected.Add(uint32(v))
+					}
+				}
+				shifted := rb.AddOffset(delta)
+				So(shifted.Validate(), ShouldBeNil)
+				So(shifted.Equals(expected), ShouldBeTrue)
+			}
+		}
+	})
+
+	Convey("AddOffset should keep run containers and leave the bitmap unchanged", t, func() {
+		rb := NewBitmap()
+		rb.AddRange(100, 200000)
+		rb.RunOptimize()
+		clone := rb.Clone()
+		shifted := rb.AddOffset(12345)
+		So(rb.Equals(clone), ShouldBeTrue)
+		So(shifted.GetCardinality(), ShouldEqual, rb.GetCardinality())
+		So(shifted.Minimum(), ShouldEqual, 12445)
+		for _, c := range shifted.highlowcontainer.containers {
+			_, isRun := c.(*runContainer16)
+			So(isRun, ShouldBeTrue)
+		}
+		shifted.Add(5)
+		So(rb.Contains(5), ShouldBeFalse)
+	})
+}
+
+func TestRanges(t *testing.T) {
+	Convey("Ranges should list the runs of the bitmap across containers", t, func() {
+		r := rand.New(rand.NewSource(2022))
+		for trial := 0; trial < 50; trial++ {
+			rb := randomBitmapForValidate(r)
+			ranges := rb.Ranges()
+			So(len(ranges), ShouldEqual, len(rangesOf(rb)))
+			for i, iv := range rangesOf(rb) {
+				So(ranges[i], ShouldResemble, Interval{Start: uint64(iv[0]), End: uint64(iv[1]) + 1})
+			}
+			back := FromRanges(ranges)
+			So(back.Validate(), ShouldBeNil)
+			So(back.Equals(rb), ShouldBeTrue)
+		}
+		rb := BitmapOf(MaxUint32)
+		rb.AddRange(1<<16-3, 1<<17+3)
+		So(rb.Ranges(), ShouldResemble, []Interval{{1<<16 - 3, 1<<17 + 3}, {MaxUint32, MaxUint32 + 1}})
+		So(NewBitmap().Ranges(), ShouldBeEmpty)
+	})
+
+	Convey("FromRanges should agree with AddRange on unsorted and overlapping ranges", t, func() {
+		r := rand.New(rand.NewSource(2023))
+		for trial := 0; trial < 100; trial++ {
+			ranges := make([]Interval, r.Intn(20))
+			expected := NewBitmap()
+			for i := range ranges {
+				start := uint64(r.Intn(5 << 16))
+				ranges[i] = Interval{Start: start, End: start + uint64(r.Intn(3<<16))}
+				if r.Intn(10) == 0 {
+					ranges[i].End = 0
+				}
+				expected.AddRange(ranges[i].Start, ranges[i].End)
+			}
+			rb := FromRanges(ranges)
+			So(rb.Validate(), ShouldBeNil)
+			So(rb.Equals(expected), ShouldBeTrue)
+		}
+		rb := FromRanges([]Interval{{MaxUint32 - 1, 1 << 40}, {0, 1 << 17}})
+		So(rb.GetCardinality(), ShouldEqual, 1<<17+2)
+		for _, c := range rb.highlowcontainer.containers[:2] {
+			_, isRun := c.(*runContainer16)
+			So(isRun, ShouldBeTrue)
+		}
+		So(FromRanges(nil).IsEmpty(), ShouldBeTrue)
+	})
+
+	Convey("FromRanges should keep runs ending at the end of a container", t, func() {
+		ranges := []Interval{{5, 6}, {65535, 65536}, {1<<17 - 3, 1 << 17}, {MaxUint32, MaxUint32 + 1}}
+		rb := FromRanges(ranges)
+		So(rb.Validate(), ShouldBeNil)
+		So(rb.ToArray(), ShouldResemble, []uint32{5, 65535, 1<<17 - 3, 1<<17 - 2, 1<<17 - 1, MaxUint32})
+		So(rb.Ranges(), ShouldResemble, ranges)
+	})
+}
+
+func TestXorInPlaceSharing064(t *testing.T) {
+	Convey("Xor should not share the containers it takes from its argument", t, func() {
+		rb1 := BitmapOf(106)
+		rb2 := NewBitmap()
+		rb2.AddRange(3<<16, 3<<16+100)
+		rb2.Xor(rb1)
+		So(rb2.GetCardinality(), ShouldEqual, 101)
+
+		rb1.Add(0)
+		So(rb2.Contains(0), ShouldBeFalse)
+		So(rb2.GetCardinality(), ShouldEqual, 101)
+		rb2.Add(1)
+		So(rb1.ToArray(), ShouldResemble, []uint32{0, 106})
+	})
+}
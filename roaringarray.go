@@ -22,6 +22,9 @@ type container interface {
 	// rank returns the number of integers that are
 	// smaller or equal to x. rank(infinity) would be getCardinality().
 	rank(uint16) int
+	getCardinalityInRange(start, end int) int // range is [start,end)
+	containsRange(start, end int) bool        // whether all of [start,end) is present
+	intersectsRange(start, end int) bool      // whether some value of [start,end) is present
 
 	iadd(x uint16) bool                   // inplace, returns true if x was new.
 	iaddReturnMinimized(uint16) container // may change return type to minimize storage.
@@ -35,7 +38,9 @@ type container interface {
 	not(start, final int) container               // range is [firstOfRange,lastOfRange)
 	inot(firstOfRange, lastOfRange int) container // i stands for inplace, range is [firstOfRange,lastOfRange)
 	xor(r container) container
-	getShortIterator() shortIterable
+	getShortIterator() shortPeekable
+	getReverseIterator() shortIterable
+	getManyIterator() manyIterable
 	contains(i uint16) bool
 
 	// equals is now logical equals; it does not require the
@@ -45,10 +50,13 @@ type container interface {
 
 	fillLeastSignificant16bits(array []uint32, i int, mask uint32)
 	or(r container) container
-	ior(r container) container   // i stands for inplace
-	intersects(r container) bool // whether the two containers intersect
+	ior(r container) container      // i stands for inplace
+	intersects(r container) bool    // whether the two containers intersect
+	andCardinality(r container) int // cardinality of the intersection, which is not computed
 	lazyOR(r container) container
 	lazyIOR(r container) container
+	lazyXOR(r container) container
+	lazyIXOR(r container) container
 	getSizeInBytes() int
 	//removeRange(start, final int) container  // range is [firstOfRange,lastOfRange) (unused)
 	iremoveRange(start, final int) container // i stands for inplace, range is [firstOfRange,lastOfRange)
@@ -58,7 +66,13 @@ type container interface {
 	writeTo(io.Writer) (int, error)
 
 	numberOfRuns() int
+	minimum() uint16 // assumes the container is not empty
+	maximum() uint16 // assumes the container is not empty
 	toEfficientContainer() container
+	// addOffset adds x to the values, it returns the values that are still
+	// smaller than 1<<16 and those that overflow to the next key, nil if empty
+	addOffset(x uint16) (lo, hi container)
+	validate() error // checks the invariants of the container
 	String() string
 	containerType() contype
 }
@@ -95,6 +109,12 @@ type roaringArray struct {
 	needCopyOnWrite []bool
 	copyOnWrite     bool
 
+	// when cacheCardinalities is set, cardinalities[i] holds the number of
+	// values in containers[0..i]; it is computed on demand and set to nil
+	// whenever a container may change.
+	cacheCardinalities bool     `msg:"-"`
+	cardinalities      []uint64 `msg:"-"`
+
 	// conserz is used at serialization time
 	// to serialize containers. Otherwise empty.
 	conserz []containerSerz
@@ -125,13 +145,16 @@ func (ra *roaringArray) runOptimize() {
 }
 
 func (ra *roaringArray) appendContainer(key uint16, value container, mustCopyOnWrite bool) {
+	ra.invalidateCardinalities()
 	ra.keys = append(ra.keys, key)
 	ra.containers = append(ra.containers, value)
 	ra.needCopyOnWrite = append(ra.needCopyOnWrite, mustCopyOnWrite)
 }
 
+// appendWithoutCopy moves a container of sa to ra, sa must not be used afterwards.
+// The container may still be shared with another bitmap, so its flag is kept.
 func (ra *roaringArray) appendWithoutCopy(sa roaringArray, startingindex int) {
-	ra.appendContainer(sa.keys[startingindex], sa.containers[startingindex], false)
+	ra.appendContainer(sa.keys[startingindex], sa.containers[startingindex], sa.needCopyOnWrite[startingindex])
 }
 
 func (ra *roaringArray) appendCopy(sa roaringArray, startingindex int) {
@@ -190,6 +213,7 @@ func (ra *roaringArray) removeIndexRange(begin, end int) {
 }
 
 func (ra *roaringArray) resize(newsize int) {
+	ra.invalidateCardinalities()
 	for k := newsize; k < len(ra.containers); k++ {
 		ra.containers[k] = nil
 	}
@@ -199,35 +223,94 @@ func (ra *roaringArray) resize(newsize int) {
 	ra.needCopyOnWrite = ra.needCopyOnWrite[:newsize]
 }
 
+// clear removes all containers, the copy-on-write and caching settings are kept
 func (ra *roaringArray) clear() {
-	*ra = roaringArray{}
+	*ra = roaringArray{copyOnWrite: ra.copyOnWrite, cacheCardinalities: ra.cacheCardinalities}
 }
 
-func (ra *roaringArray) clone() *roaringArray {
+func (ra *roaringArray) invalidateCardinalities() {
+	ra.cardinalities = nil
+}
 
-	// shallow copy, slices will have the same backing arrays.
-	sa := *ra
+// cumulativeCardinalities returns the running totals of the container
+// cardinalities, computing them if they are not cached. The returned
+// slice is never modified afterwards, it is replaced when invalidated.
+func (ra *roaringArray) cumulativeCardinalities() []uint64 {
+	if ra.cardinalities != nil {
+		return ra.cardinalities
+	}
+	cards := make([]uint64, len(ra.containers))
+	total := uint64(0)
+	for i, c := range ra.containers {
+		total += uint64(c.getCardinality())
+		cards[i] = total
+	}
+	if ra.cacheCardinalities {
+		ra.cardinalities = cards
+	}
+	return cards
+}
 
+func (ra *roaringArray) clone() *roaringArray {
 	// this is where copyOnWrite is used.
 	if ra.copyOnWrite {
-		ra.markAllAsNeedingCopyOnWrite()
-		// sa.needCopyOnWrite is shared
-	} else {
-		// make a full copy
+		return ra.cloneCopyOnWrite()
+	}
 
-		sa.keys = make([]uint16, len(ra.keys))
-		copy(sa.keys, ra.keys)
+	// make a full copy
+	sa := *ra
 
-		sa.containers = make([]container, len(ra.containers))
-		for i := range sa.containers {
-			sa.containers[i] = ra.containers[i].clone()
-		}
+	sa.keys = make([]uint16, len(ra.keys))
+	copy(sa.keys, ra.keys)
 
-		sa.needCopyOnWrite = make([]bool, len(ra.needCopyOnWrite))
+	sa.containers = make([]container, len(ra.containers))
+	for i := range sa.containers {
+		sa.containers[i] = ra.containers[i].clone()
 	}
+
+	sa.needCopyOnWrite = make([]bool, len(ra.needCopyOnWrite))
 	return &sa
 }
 
+// cloneCopyOnWrite returns a copy of ra sharing its containers: both ra
+// and the copy mark every container as needing a copy before it is modified.
+// The slices themselves are never shared, so that inserting or replacing a
+// container in one array is not seen by the other.
+func (ra *roaringArray) cloneCopyOnWrite() *roaringArray {
+	sa := *ra
+
+	sa.keys = make([]uint16, len(ra.keys))
+	copy(sa.keys, ra.keys)
+
+	sa.containers = make([]container, len(ra.containers))
+	copy(sa.containers, ra.containers)
+
+	sa.needCopyOnWrite = make([]bool, len(ra.needCopyOnWrite))
+
+	ra.markAllAsNeedingCopyOnWrite()
+	sa.markAllAsNeedingCopyOnWrite()
+	return &sa
+}
+
+func (ra *roaringArray) validate() error {
+	if len(ra.keys) != len(ra.containers) || len(ra.keys) != len(ra.needCopyOnWrite) {
+		return fmt.Errorf("invalid bitmap: %d keys, %d containers and %d copy-on-write flags",
+			len(ra.keys), len(ra.containers), len(ra.needCopyOnWrite))
+	}
+	for i, c := range ra.containers {
+		if i > 0 && ra.keys[i] <= ra.keys[i-1] {
+			return fmt.Errorf("invalid bitmap: keys are not strictly increasing at position %d", i)
+		}
+		if c == nil {
+			return fmt.Errorf("invalid bitmap: nil container for key %d", ra.keys[i])
+		}
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("invalid bitmap: key %d: %v", ra.keys[i], err)
+		}
+	}
+	return nil
+}
+
 func (ra *roaringArray) containsKey(x uint16) bool {
 	return (ra.binarySearch(0, int64(len(ra.keys)), x) >= 0)
 }
@@ -245,6 +328,7 @@ func (ra *roaringArray) getWritableContainerContainer(x uint16) container {
 	if i < 0 {
 		return nil
 	}
+	ra.invalidateCardinalities()
 	if ra.needCopyOnWrite[i] {
 		ra.containers[i] = ra.containers[i].clone()
 		ra.needCopyOnWrite[i] = false
@@ -257,6 +341,7 @@ func (ra *roaringArray) getContainerAtIndex(i int) container {
 }
 
 func (ra *roaringArray) getWritableContainerAtIndex(i int) container {
+	ra.invalidateCardinalities()
 	if ra.needCopyOnWrite[i] {
 		ra.containers[i] = ra.containers[i].clone()
 		ra.needCopyOnWrite[i] = false
@@ -278,6 +363,7 @@ func (ra *roaringArray) getKeyAtIndex(i int) uint16 {
 }
 
 func (ra *roaringArray) insertNewKeyValueAt(i int, key uint16, value container) {
+	ra.invalidateCardinalities()
 	ra.keys = append(ra.keys, 0)
 	ra.containers = append(ra.containers, nil)
 
@@ -311,10 +397,12 @@ func (ra *roaringArray) removeAtIndex(i int) {
 }
 
 func (ra *roaringArray) setContainerAtIndex(i int, c container) {
+	ra.invalidateCardinalities()
 	ra.containers[i] = c
 }
 
 func (ra *roaringArray) replaceKeyAndContainerAtIndex(i int, key uint16, c container, mustCopyOnWrite bool) {
+	ra.invalidateCardinalities()
 	ra.keys[i] = key
 	ra.containers[i] = c
 	ra.needCopyOnWrite[i] = mustCopyOnWrite
@@ -376,53 +464,72 @@ func (ra *roaringArray) equals(o interface{}) bool {
 
 // this can be expensive; don't call it unnecessarily in production
 func (ra *roaringArray) serializedSizeInBytes() uint64 {
-	n, _ := ra.writeToHelper(nil, true)
-	return uint64(n)
+	hasRun := ra.hasRunContainer()
+	size := uint64(ra.headerSizeInBytes(hasRun))
+	for _, c := range ra.containers {
+		size += uint64(c.serializedSizeInBytes())
+	}
+	return size
 }
 
-//
-// spec: https://github.com/RoaringBitmap/RoaringFormatSpec
-//
-func (ra *roaringArray) writeTo(out io.Writer) (int64, error) {
-	return ra.writeToHelper(out, false)
+func (ra *roaringArray) hasRunContainer() bool {
+	for _, c := range ra.containers {
+		if _, ok := c.(*runContainer16); ok {
+			return true
+		}
+	}
+	return false
 }
 
-// if fake then `out io.Writer` won't be touched, and the number of bytes
-// that would have been written and nil will be returned
-func (ra *roaringArray) writeToHelper(out io.Writer, fake bool) (int64, error) {
-	stream := &bytes.Buffer{}
-
-	//p("roaringArray.writeTo starting")
+// headerSizeInBytes returns the size of the serialized header: the cookie,
+// the isRun bitset, the keys and cardinalities and the offsets if any
+func (ra *roaringArray) headerSizeInBytes(hasRun bool) int {
 	numKeys := len(ra.keys)
-	isRunSizeInBytes := (numKeys + 7) / 8
-
-	// compute isRun bitmap
-	var ir []byte
-	isRun := newBitmapContainer()
-	for i, c := range ra.containers {
-		switch c.(type) {
-		case *runContainer16:
-			isRun.iadd(uint16(i))
+	if hasRun {
+		size := 4 + (numKeys+7)/8 + 4*numKeys
+		if numKeys >= noOffsetThreshold {
+			size += 4 * numKeys
 		}
+		return size
 	}
-	ir = uint64SliceAsByteSlice(isRun.bitmap)[:isRunSizeInBytes]
-
-	const cookieSize = 4
-	descriptiveHeaderSize := 4 * len(ra.keys)
-	preambleSize := cookieSize + isRunSizeInBytes + descriptiveHeaderSize
+	return 4 + 4 + 8*numKeys
+}
 
-	buf := make([]byte, preambleSize+4*len(ra.keys))
-	binary.LittleEndian.PutUint16(buf[0:], uint16(serialCookie))
-	if len(ra.keys) > MaxUint16 {
+// header returns the serialized header, the containers follow it.
+//
+// Bitmaps without run containers are written with serialCookieNoRunContainer,
+// a 32-bit container count and an offset header, as the Java and C
+// implementations and the format specification do. Earlier versions used
+// serialCookie with an all-zero isRun bitset for them, which cannot encode
+// an empty bitmap since the count is stored minus one; readFrom still
+// accepts both layouts, so the bitmaps they wrote remain readable.
+func (ra *roaringArray) header() []byte {
+	numKeys := len(ra.keys)
+	if numKeys > MaxUint16+1 {
 		panic("should be impossible to have this many keys")
 	}
-	binary.LittleEndian.PutUint16(buf[2:], uint16(len(ra.keys)-1))
-	nw := 4
-	//p("after writing serialCookie and number of keys -1, now nw: %v", nw)
 
-	// isRun bitset
-	nw += copy(buf[nw:], ir)
-	//p("after writing isRun array of len(%v), now nw: %v, this will be start of descriptive header", len(ir), nw)
+	hasRun := ra.hasRunContainer()
+	buf := make([]byte, ra.headerSizeInBytes(hasRun))
+	nw := 0
+	if hasRun {
+		binary.LittleEndian.PutUint16(buf[0:], uint16(serialCookie))
+		binary.LittleEndian.PutUint16(buf[2:], uint16(numKeys-1))
+		nw = 4
+		// isRun bitset
+		for i, c := range ra.containers {
+			if _, ok := c.(*runContainer16); ok {
+				buf[nw+i/8] |= 1 << uint(i%8)
+			}
+		}
+		nw += (numKeys + 7) / 8
+	} else {
+		// without run containers, the size is stored on its own so that
+		// an empty bitmap can be represented
+		binary.LittleEndian.PutUint32(buf[0:], uint32(serialCookieNoRunContainer))
+		binary.LittleEndian.PutUint32(buf[4:], uint32(numKeys))
+		nw = 8
+	}
 
 	// descriptive header
 	for i, key := range ra.keys {
@@ -432,194 +539,291 @@ func (ra *roaringArray) writeToHelper(out io.Writer, fake bool) (int64, error) {
 		binary.LittleEndian.PutUint16(buf[nw:], uint16(c.getCardinality()-1))
 		nw += 2
 	}
-	//p("after writing descriptiveHeader, now nw: %v", nw)
 
-	startOffset := int64(preambleSize + 4*len(ra.keys))
-	if len(ra.keys) >= noOffsetThreshold {
-		//p("writing offset header at nw: %v", nw)
+	if !hasRun || numKeys >= noOffsetThreshold {
 		// offset header
+		startOffset := uint32(len(buf))
 		for _, c := range ra.containers {
-			binary.LittleEndian.PutUint32(buf[nw:], uint32(startOffset))
+			binary.LittleEndian.PutUint32(buf[nw:], startOffset)
 			nw += 4
-			switch rc := c.(type) {
-			case *runContainer16:
-				startOffset += 2 + int64(len(rc.iv))*4
-			default:
-				startOffset += int64(getSizeInBytesFromCardinality(c.getCardinality()))
-			}
+			startOffset += uint32(c.serializedSizeInBytes())
 		}
-	} else {
-		//p("skiping offset header at nw %v", nw)
 	}
+	return buf
+}
 
-	if !fake {
-		nsw, err := stream.Write(buf[:nw])
-		if err != nil {
-			return 0, err
-		}
-		if nsw != nw {
-			panic("short write!")
-		}
+//
+// spec: https://github.com/RoaringBitmap/RoaringFormatSpec
+//
+// writeTo writes the header, then each container in turn directly to out.
+// On error, the returned count is the number of bytes actually written.
+func (ra *roaringArray) writeTo(out io.Writer) (int64, error) {
+	header := ra.header()
+	n, err := out.Write(header)
+	written := int64(n)
+	if err == nil && n < len(header) {
+		err = io.ErrShortWrite
 	}
-	for i, c := range ra.containers {
-		_ = i
-		//p("writeTo writing %v-th container with key %v (card: %v) at fileloc: %v / nw: %v", i, ra.keys[i], c.getCardinality(), len(stream.Bytes()), nw)
-		writ, err := c.writeTo(stream)
+	if err != nil {
+		return written, err
+	}
+	for _, c := range ra.containers {
+		n, err := c.writeTo(out)
+		written += int64(n)
+		if err == nil && n < c.serializedSizeInBytes() {
+			err = io.ErrShortWrite
+		}
 		if err != nil {
-			return 0, err
+			return written, err
 		}
-		nw += writ
 	}
+	return written, nil
+}
 
-	by := stream.Bytes()
+// readFrom reads a serialized roaringArray from stream, replacing the
+// content of ra. The input is fully validated: a malformed input gives
+// ErrInvalidFormat, an input that ends too early gives ErrTruncated.
+func (ra *roaringArray) readFrom(stream io.Reader) (int64, error) {
+	ra.clear()
+	pos := 0
 
-	if fake {
-		return int64(nw), nil
+	var buf [4]byte
+	n, err := io.ReadFull(stream, buf[:])
+	pos += n
+	if err != nil {
+		return int64(pos), readError(err)
 	}
+	cookie := binary.LittleEndian.Uint32(buf[:])
 
-	n, err := out.Write(by)
-	if err == nil && n < len(by) {
-		err = io.ErrShortWrite
+	var size int
+	var isRun []byte
+	haveRunContainers := false
+	if cookie&0x0000FFFF == serialCookie {
+		haveRunContainers = true
+		size = int(cookie>>16) + 1
+		isRun = make([]byte, (size+7)/8)
+		n, err = io.ReadFull(stream, isRun)
+		pos += n
+		if err != nil {
+			return int64(pos), readError(err)
+		}
+	} else if cookie == serialCookieNoRunContainer {
+		n, err = io.ReadFull(stream, buf[:])
+		pos += n
+		if err != nil {
+			return int64(pos), readError(err)
+		}
+		// checked before allocating anything based on it
+		if binary.LittleEndian.Uint32(buf[:]) > maxCapacity {
+			return int64(pos), ErrInvalidFormat
+		}
+		size = int(binary.LittleEndian.Uint32(buf[:]))
+	} else {
+		return int64(pos), ErrInvalidFormat
 	}
-	return int64(n), err
-}
 
-func (ra *roaringArray) readFrom(stream io.Reader) (int64, error) {
+	// descriptive header
+	keycard := make([]byte, 4*size)
+	n, err = io.ReadFull(stream, keycard)
+	pos += n
+	if err != nil {
+		return int64(pos), readError(err)
+	}
+	if !validKeycard(keycard) {
+		return int64(pos), ErrInvalidFormat
+	}
 
-	pos := 0
+	// offset header
+	var offsets []byte
+	if !haveRunContainers || size >= noOffsetThreshold {
+		offsets = make([]byte, 4*size)
+		n, err = io.ReadFull(stream, offsets)
+		pos += n
+		if err != nil {
+			return int64(pos), readError(err)
+		}
+	}
 
-	var cookie uint32
-	err := binary.Read(stream, binary.LittleEndian, &cookie)
+	keys := make([]uint16, 0, size)
+	containers := make([]container, 0, size)
+	for i := 0; i < size; i++ {
+		key := binary.LittleEndian.Uint16(keycard[4*i:])
+		card := int(binary.LittleEndian.Uint16(keycard[4*i+2:])) + 1
+		if offsets != nil && binary.LittleEndian.Uint32(offsets[4*i:]) != uint32(pos) {
+			return int64(pos), ErrInvalidFormat
+		}
 
-	if err != nil {
-		return 0, fmt.Errorf("error in roaringArray.readFrom: could not read initial cookie: %s", err)
+		var c container
+		if haveRunContainers && isRun[i/8]&(1<<uint(i%8)) != 0 {
+			nb := newRunContainer16()
+			n, err = nb.readFrom(stream)
+			pos += n
+			if err != nil {
+				return int64(pos), readError(err)
+			}
+			if nb.cardinality() != int64(card) {
+				return int64(pos), ErrInvalidFormat
+			}
+			c = nb
+		} else if card > arrayDefaultMaxSize {
+			nb := newBitmapContainer()
+			n, err = nb.readFrom(stream)
+			pos += n
+			if err != nil {
+				return int64(pos), readError(err)
+			}
+			if nb.cardinality != card {
+				return int64(pos), ErrInvalidFormat
+			}
+			c = nb
+		} else {
+			nb := newArrayContainerSize(card)
+			n, err = nb.readFrom(stream)
+			pos += n
+			if err != nil {
+				return int64(pos), readError(err)
+			}
+			if !validArrayContent(nb.content) {
+				return int64(pos), ErrInvalidFormat
+			}
+			c = nb
+		}
+		keys = append(keys, key)
+		containers = append(containers, c)
 	}
-	pos += 4
+	ra.keys = keys
+	ra.containers = containers
+	ra.needCopyOnWrite = make([]bool, size)
+	return int64(pos), nil
+}
 
-	if cookie != serialCookieNoRunContainer && cookie&0x0000FFFF != serialCookie {
-		return 0, fmt.Errorf("error in roaringArray.readFrom: did not find expected serialCookie in header")
+// fromBuffer points ra at the containers serialized in buf, following
+// the same format and validation as readFrom. Array and bitmap containers
+// share their storage with buf instead of being copied; every container is
+// flagged as needing copy-on-write so that any mutation works on a copy and
+// buf is never modified. Run containers are decoded since their in-memory
+// layout differs from the serialized one.
+func (ra *roaringArray) fromBuffer(buf []byte) (int64, error) {
+	ra.clear()
+	if len(buf) < 4 {
+		return int64(len(buf)), ErrTruncated
 	}
+	pos := 4
+	cookie := binary.LittleEndian.Uint32(buf)
 
-	var size uint32
+	var size int
+	var isRun []byte
 	haveRunContainers := false
-	var isRun container = newRunContainer16()
 	if cookie&0x0000FFFF == serialCookie {
 		haveRunContainers = true
-		size = (cookie >> 16) + 1
-		//p("stream contains run containers. total number of containers == size = %v", size)
-		bytesToRead := (int(size) + 7) / 8
-		//p("bytesToRead = %v", bytesToRead)
-		by := make([]byte, bytesToRead)
-		nr, err := io.ReadAtLeast(stream, by, bytesToRead)
-		if err != nil {
-			return 8 + int64(nr), fmt.Errorf("error in readFrom: could not read the "+
-				"runContainer bit flags of length %v bytes: %v", bytesToRead, err)
+		size = int(cookie>>16) + 1
+		isRunSize := (size + 7) / 8
+		if len(buf)-pos < isRunSize {
+			return int64(len(buf)), ErrTruncated
 		}
-		pos += nr
-		//p("by = %#v", by)
-
-		bc := newBitmapContainer()
-		asBy := uint64SliceAsByteSlice(bc.bitmap)
-		for i := range by {
-			asBy[i] = by[i]
+		isRun = buf[pos : pos+isRunSize]
+		pos += isRunSize
+	} else if cookie == serialCookieNoRunContainer {
+		if len(buf)-pos < 4 {
+			return int64(len(buf)), ErrTruncated
 		}
-		bc.computeCardinality()
-		//p("bc = %s", bc)
-		isRun = bc
-		//p("read in isRun bitmap container ok: '%s'", bc.String())
-		//p("after read of isRun bitmap, now at pos = %v", pos)
-	} else {
-		err = binary.Read(stream, binary.LittleEndian, &size)
-		if err != nil {
-			return 0, fmt.Errorf("error in roaringArray.readFrom: when reading size, got: %s", err)
+		if binary.LittleEndian.Uint32(buf[pos:]) > maxCapacity {
+			return int64(pos), ErrInvalidFormat
 		}
+		size = int(binary.LittleEndian.Uint32(buf[pos:]))
 		pos += 4
-		//p("!serialCookie, so after skip of isRun bitmap, and read of size, now at pos = %v", pos)
+	} else {
+		return int64(pos), ErrInvalidFormat
 	}
 
 	// descriptive header
-	keycard := make([]uint16, 2*size, 2*size)
-	err = binary.Read(stream, binary.LittleEndian, keycard)
-	if err != nil {
-		return 0, err
-	}
-	//p("got descriptive header, starting at pos %v:", pos)
-	pos += 2 * 2 * int(size)
-	//p("descriptive header ends at pos %v:", pos)
-
-	k := 0
-	for i := 0; i < len(keycard); i += 2 {
-		if rleVerbose {
-			// debug stuff here:
-			yesRun := false
-			if isRun.contains(uint16(k)) {
-				yesRun = true
-			}
-			fmt.Printf("\n    descriptive header: key %x:  card %v   isRun: %v\n", keycard[i], int(keycard[i+1])+1, yesRun)
-		}
-		k++
+	if len(buf)-pos < 4*size {
+		return int64(len(buf)), ErrTruncated
+	}
+	keycard := buf[pos : pos+4*size]
+	pos += 4 * size
+	if !validKeycard(keycard) {
+		return int64(pos), ErrInvalidFormat
 	}
 
 	// offset header
+	var offsets []byte
 	if !haveRunContainers || size >= noOffsetThreshold {
-		//p("reading offset header of size %v at pos %v", size, pos)
-		offsets := make([]uint32, size, size)
-		err = binary.Read(stream, binary.LittleEndian, offsets)
-		if err != nil {
-			return 0, err
+		if len(buf)-pos < 4*size {
+			return int64(len(buf)), ErrTruncated
 		}
-		pos += 4 * int(size)
-		//p("read offset header of count %v. offsets: %v. pos after is %v", size, offsets, pos)
-	} else {
-		//p("skipping/should be no offset header, because size %v < noOffsetThreshold.  pos is now %v", size, pos)
-	}
-	//offset := int64(4 + 4 + 8*size) // probably wrong now
-	for i := uint32(0); i < size; i++ {
-		key := int(keycard[2*i])
-		card := int(keycard[2*i+1]) + 1
-		if haveRunContainers && isRun.contains(uint16(i)) {
-			//p("at key %v, filepos %v, reading a run container", key, pos)
-			nb := newRunContainer16()
-			nr, err := nb.readFrom(stream)
+		offsets = buf[pos : pos+4*size]
+		pos += 4 * size
+	}
+
+	keys := make([]uint16, 0, size)
+	containers := make([]container, 0, size)
+	for i := 0; i < size; i++ {
+		key := binary.LittleEndian.Uint16(keycard[4*i:])
+		card := int(binary.LittleEndian.Uint16(keycard[4*i+2:])) + 1
+		if offsets != nil && binary.LittleEndian.Uint32(offsets[4*i:]) != uint32(pos) {
+			return int64(pos), ErrInvalidFormat
+		}
+
+		var c container
+		if haveRunContainers && isRun[i/8]&(1<<uint(i%8)) != 0 {
+			if len(buf)-pos < 2 {
+				return int64(len(buf)), ErrTruncated
+			}
+			nr := int(binary.LittleEndian.Uint16(buf[pos:]))
+			pos += 2
+			if len(buf)-pos < 4*nr {
+				return int64(len(buf)), ErrTruncated
+			}
+			iv, rcard, err := decodeRuns16(buf[pos : pos+4*nr])
+			pos += 4 * nr
 			if err != nil {
-				return 0, err
+				return int64(pos), err
+			}
+			if rcard != int64(card) {
+				return int64(pos), ErrInvalidFormat
 			}
-			//p("at key %v, filepos %v, read a run container: %s", key, pos, nb)
-			pos += nr
-			//offset += 2 + int64(len(nb.iv))*4
-			ra.appendContainer(uint16(key), nb, false)
+			c = &runContainer16{iv: iv, card: rcard}
+		} else if card > arrayDefaultMaxSize {
+			if len(buf)-pos < maxCapacity/8 {
+				return int64(len(buf)), ErrTruncated
+			}
+			bitmap := byteSliceAsUint64Slice(buf[pos : pos+maxCapacity/8])
+			pos += maxCapacity / 8
+			if int(popcntSlice(bitmap)) != card {
+				return int64(pos), ErrInvalidFormat
+			}
+			c = &bitmapContainer{cardinality: card, bitmap: bitmap}
 		} else {
-			//offset += int64(getSizeInBytesFromCardinality(card))
-			if card > arrayDefaultMaxSize {
-				//p("at key %v, filepos %v, reading a bitmap container", key, pos)
-
-				nb := newBitmapContainer()
-				nr, err := nb.readFrom(stream)
-				if err != nil {
-					return 0, err
-				}
-				nb.cardinality = int(card)
-				//p("at key %v, filepos %v, read a bitmap container = %s", key, pos, nb)
-				pos += nr
-				ra.appendContainer(keycard[2*i], nb, false)
-			} else {
-				//p("at key %v, filepos %v, reading an array container with card %v", key, pos, card)
-
-				nb := newArrayContainerSize(int(card))
-				nr, err := nb.readFrom(stream)
-				if err != nil {
-					return 0, err
-				}
-				//p("at key %v, filepos %v, read an array container (card %v) = %s",key, pos, card, nb)
-				pos += nr
-				ra.appendContainer(keycard[2*i], nb, false)
+			if len(buf)-pos < 2*card {
+				return int64(len(buf)), ErrTruncated
+			}
+			content := byteSliceAsUint16Slice(buf[pos : pos+2*card])
+			pos += 2 * card
+			if !validArrayContent(content) {
+				return int64(pos), ErrInvalidFormat
 			}
+			c = &arrayContainer{content}
 		}
+		keys = append(keys, key)
+		containers = append(containers, c)
 	}
+	ra.keys = keys
+	ra.containers = containers
+	ra.needCopyOnWrite = make([]bool, size)
+	ra.markAllAsNeedingCopyOnWrite()
 	return int64(pos), nil
 }
 
-func (ra *roaringArray) writeToMsgpack(stream io.Writer) error {
+// msgpackFormatVersion is the byte written before the snappy stream of the
+// msgpack serialization, it must be increased whenever the format changes.
+// Streams written before it was introduced start directly with the snappy
+// stream identifier (0xff) and are still accepted.
+const msgpackFormatVersion = 1
+
+// writeToMsgpack writes the version byte followed by the snappy-compressed
+// msgpack serialization of ra, and returns the number of bytes written to stream
+func (ra *roaringArray) writeToMsgpack(stream io.Writer) (int64, error) {
 
 	ra.conserz = make([]containerSerz, len(ra.containers))
 	for i, v := range ra.containers {
@@ -627,39 +831,64 @@ func (ra *roaringArray) writeToMsgpack(stream io.Writer) error {
 		case *bitmapContainer:
 			bts, err := cn.MarshalMsg(nil)
 			if err != nil {
-				return err
+				return 0, err
 			}
 			ra.conserz[i].t = bitmapContype
 			ra.conserz[i].r = bts
 		case *arrayContainer:
 			bts, err := cn.MarshalMsg(nil)
 			if err != nil {
-				return err
+				return 0, err
 			}
 			ra.conserz[i].t = arrayContype
 			ra.conserz[i].r = bts
 		case *runContainer16:
 			bts, err := cn.MarshalMsg(nil)
 			if err != nil {
-				return err
+				return 0, err
 			}
 			ra.conserz[i].t = run16Contype
 			ra.conserz[i].r = bts
 		default:
-			fmt.Errorf("Unrecognized container implementation: %T", cn)
+			ra.conserz = nil
+			return 0, fmt.Errorf("unrecognized container implementation: %T", cn)
 		}
 	}
-	w := snappy.NewWriter(stream)
-	err := msgp.Encode(w, ra)
+	cw := &countingWriter{w: stream}
+	_, err := cw.Write([]byte{msgpackFormatVersion})
+	if err == nil {
+		err = msgp.Encode(snappy.NewWriter(cw), ra)
+	}
 	ra.conserz = nil
-	return err
-}
-
-func (ra *roaringArray) readFromMsgpack(stream io.Reader) error {
-	r := snappy.NewReader(stream)
+	return cw.n, err
+}
+
+// readFromMsgpack reads the output of writeToMsgpack, or a stream written
+// before the version byte was introduced, and returns the number of bytes
+// read from stream. The snappy decoder reads ahead, so that this count can
+// include bytes following the serialized bitmap.
+func (ra *roaringArray) readFromMsgpack(stream io.Reader) (int64, error) {
+	ra.invalidateCardinalities()
+	cr := &countingReader{r: stream}
+	var version [1]byte
+	if _, err := io.ReadFull(cr, version[:]); err != nil {
+		return cr.n, readError(err)
+	}
+	var r io.Reader
+	switch version[0] {
+	case msgpackFormatVersion:
+		r = snappy.NewReader(cr)
+	case snappy.SnappyStreamHeaderMagic[0]:
+		r = snappy.NewReader(io.MultiReader(bytes.NewReader(version[:]), cr))
+	default:
+		return cr.n, ErrUnsupportedVersion
+	}
 	err := msgp.Decode(r, ra)
 	if err != nil {
-		return err
+		return cr.n, err
+	}
+	if len(ra.conserz) != len(ra.keys) {
+		return cr.n, ErrInvalidFormat
 	}
 
 	if len(ra.containers) != len(ra.keys) {
@@ -672,29 +901,40 @@ func (ra *roaringArray) readFromMsgpack(stream io.Reader) error {
 			c := &bitmapContainer{}
 			_, err = c.UnmarshalMsg(v.r)
 			if err != nil {
-				return err
+				return cr.n, err
 			}
 			ra.containers[i] = c
 		case arrayContype:
 			c := &arrayContainer{}
 			_, err = c.UnmarshalMsg(v.r)
 			if err != nil {
-				return err
+				return cr.n, err
 			}
 			ra.containers[i] = c
 		case run16Contype:
 			c := &runContainer16{}
 			_, err = c.UnmarshalMsg(v.r)
 			if err != nil {
-				return err
+				return cr.n, err
+			}
+			ra.containers[i] = c
+		case run32Contype:
+			c32 := &runContainer32{}
+			_, err = c32.UnmarshalMsg(v.r)
+			if err != nil {
+				return cr.n, err
+			}
+			c, err := c32.toRunContainer16()
+			if err != nil {
+				return cr.n, err
 			}
 			ra.containers[i] = c
 		default:
-			return fmt.Errorf("unrecognized contype serialization code: '%v'", v.t)
+			return cr.n, fmt.Errorf("unrecognized contype serialization code: '%v'", v.t)
 		}
 	}
 	ra.conserz = nil
-	return nil
+	return cr.n, nil
 }
 
 func (ra *roaringArray) advanceUntil(min uint16, pos int) int {
@@ -2,12 +2,99 @@ package roaring
 
 import (
 	"encoding/binary"
-	"fmt"
+	"errors"
 	"io"
 
 	"github.com/tinylib/msgp/msgp"
 )
 
+var (
+	// ErrInvalidFormat is returned when deserializing an input that does not
+	// follow the format specification, or that describes an inconsistent bitmap
+	ErrInvalidFormat = errors.New("roaring: invalid serialized bitmap")
+
+	// ErrTruncated is returned when deserializing an input that ends before
+	// the serialized bitmap is complete
+	ErrTruncated = errors.New("roaring: truncated serialized bitmap")
+
+	// ErrUnsupportedVersion is returned when deserializing a msgpack bitmap
+	// written with a format version that this package does not know
+	ErrUnsupportedVersion = errors.New("roaring: unsupported msgpack format version")
+)
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// readError converts the errors of a read that ended too early to ErrTruncated
+func readError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+// validKeycard checks that the keys of a descriptive header are strictly increasing
+func validKeycard(keycard []byte) bool {
+	for i := 4; i < len(keycard); i += 4 {
+		if binary.LittleEndian.Uint16(keycard[i:]) <= binary.LittleEndian.Uint16(keycard[i-4:]) {
+			return false
+		}
+	}
+	return true
+}
+
+// validArrayContent checks that the values of an array container are strictly increasing
+func validArrayContent(content []uint16) bool {
+	for i := 1; i < len(content); i++ {
+		if content[i] <= content[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeRuns16 decodes serialized (start, length) pairs, checking that the
+// runs are sorted, neither overlap nor touch and stay within 16 bits.
+func decodeRuns16(encRun []byte) ([]interval16, int64, error) {
+	nr := len(encRun) / 4
+	iv := make([]interval16, nr)
+	card := int64(0)
+	for i := 0; i < nr; i++ {
+		start := binary.LittleEndian.Uint16(encRun[4*i:])
+		length := binary.LittleEndian.Uint16(encRun[4*i+2:])
+		if int(start)+int(length) > MaxUint16 {
+			return nil, 0, ErrInvalidFormat
+		}
+		if i > 0 && int(iv[i-1].last)+1 >= int(start) {
+			return nil, 0, ErrInvalidFormat
+		}
+		iv[i] = interval16{start: start, last: start + length}
+		card += int64(length) + 1
+	}
+	return iv, card, nil
+}
+
 // writeTo for runContainer16 follows this
 // spec: https://github.com/RoaringBitmap/RoaringFormatSpec
 //
@@ -38,44 +125,47 @@ func (b *runContainer16) writeToMsgpack(stream io.Writer) (int, error) {
 }
 
 func (b *runContainer32) readFromMsgpack(stream io.Reader) (int, error) {
-	err := msgp.Decode(stream, b)
-	return 0, err
+	cr := &countingReader{r: stream}
+	err := msgp.Decode(cr, b)
+	return int(cr.n), err
 }
 
 func (b *runContainer16) readFromMsgpack(stream io.Reader) (int, error) {
-	err := msgp.Decode(stream, b)
-	return 0, err
+	cr := &countingReader{r: stream}
+	err := msgp.Decode(cr, b)
+	return int(cr.n), err
+}
+
+// toRunContainer16 converts a run container whose values all fit on 16 bits,
+// it returns ErrInvalidFormat otherwise or if the runs are not sorted and
+// separated by at least one missing value
+func (b *runContainer32) toRunContainer16() (*runContainer16, error) {
+	iv := make([]interval16, len(b.iv))
+	for i, v := range b.iv {
+		if v.start > v.last || v.last > MaxUint16 || i > 0 && v.start <= b.iv[i-1].last+1 {
+			return nil, ErrInvalidFormat
+		}
+		iv[i] = interval16{start: uint16(v.start), last: uint16(v.last)}
+	}
+	return newRunContainer16TakeOwnership(iv), nil
 }
 
 func (b *runContainer16) readFrom(stream io.Reader) (int, error) {
-	b.iv = b.iv[:0]
-	b.card = 0
-	var numRuns uint16
-	err := binary.Read(stream, binary.LittleEndian, &numRuns)
+	var buf [2]byte
+	n, err := io.ReadFull(stream, buf[:])
 	if err != nil {
-		return 0, err
+		return n, err
 	}
-	encRun := make([]uint16, 2*numRuns)
-	bEncRun := uint16SliceAsByteSlice(encRun)
-	err = binary.Read(stream, binary.LittleEndian, &bEncRun)
+	encRun := make([]byte, 4*int(binary.LittleEndian.Uint16(buf[:])))
+	m, err := io.ReadFull(stream, encRun)
 	if err != nil {
-		return 0, err
+		return n + m, err
 	}
-	//p("encRun = %#v", encRun)
-	nr := int(numRuns)
-	//p("runContainer16.readFrom: I see %v runs; len(encRun)=%v", numRuns, len(encRun))
-	for i := 0; i < nr; i++ {
-		//p("i = %v. b.iv = %#v", i, b.iv)
-		if i > 0 && b.iv[i-1].last >= encRun[i*2] {
-			panic(fmt.Errorf("error: stored runContainer had runs that were not in sorted order!! (b.iv[i-1=%v].last = %v >= encRun[i=%v] = %v)", i-1, b.iv[i-1].last, i, encRun[i*2]))
-		}
-		b.iv = append(b.iv, interval16{start: encRun[i*2], last: encRun[i*2] + encRun[i*2+1]})
-
-		//p("i=%v run: start:%v len:%v", i, encRun[i*2], encRun[i*2+1])
-		//p("i=%v after append, b.iv = %#v", i, b.iv)
-
-		b.card += int64(encRun[i*2+1]) + 1
+	iv, card, err := decodeRuns16(encRun)
+	if err != nil {
+		return n + m, err
 	}
-	//p("exiting runContainer16 readFrom")
-	return 0, err
+	b.iv = iv
+	b.card = card
+	return n + m, nil
 }
@@ -47,5 +47,26 @@ func (b *bitmapContainer) readFrom(stream io.Reader) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	b.computeCardinality()
 	return 8 * len(b.bitmap), nil
 }
+
+// byteSliceAsUint16Slice decodes slice as little-endian uint16 values.
+// On this platform the result is a copy and does not share memory with slice.
+func byteSliceAsUint16Slice(slice []byte) []uint16 {
+	result := make([]uint16, len(slice)/2)
+	for i := range result {
+		result[i] = binary.LittleEndian.Uint16(slice[2*i:])
+	}
+	return result
+}
+
+// byteSliceAsUint64Slice decodes slice as little-endian uint64 values.
+// On this platform the result is a copy and does not share memory with slice.
+func byteSliceAsUint64Slice(slice []byte) []uint64 {
+	result := make([]uint64, len(slice)/8)
+	for i := range result {
+		result[i] = binary.LittleEndian.Uint64(slice[8*i:])
+	}
+	return result
+}
@@ -57,3 +57,31 @@ func uint16SliceAsByteSlice(slice []uint16) []byte {
 	// return it
 	return *(*[]byte)(unsafe.Pointer(&header))
 }
+
+// byteSliceAsUint16Slice returns a []uint16 sharing the memory of slice.
+// The length of slice should be a multiple of 2.
+func byteSliceAsUint16Slice(slice []byte) []uint16 {
+	if len(slice) == 0 {
+		return nil
+	}
+	var result []uint16
+	header := (*reflect.SliceHeader)(unsafe.Pointer(&result))
+	header.Data = uintptr(unsafe.Pointer(&slice[0]))
+	header.Len = len(slice) / 2
+	header.Cap = len(slice) / 2
+	return result
+}
+
+// byteSliceAsUint64Slice returns a []uint64 sharing the memory of slice.
+// The length of slice should be a multiple of 8.
+func byteSliceAsUint64Slice(slice []byte) []uint64 {
+	if len(slice) == 0 {
+		return nil
+	}
+	var result []uint64
+	header := (*reflect.SliceHeader)(unsafe.Pointer(&result))
+	header.Data = uintptr(unsafe.Pointer(&slice[0]))
+	header.Len = len(slice) / 8
+	header.Cap = len(slice) / 8
+	return result
+}
@@ -4,14 +4,20 @@ package roaring
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"strings"
 	"testing"
 
+	snappy "github.com/glycerine/go-unsnap-stream"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tinylib/msgp/msgp"
 )
 
 func TestBase64_036(t *testing.T) {
@@ -593,6 +599,89 @@ func TestSerializationBasicMsgpack035(t *testing.T) {
 	})
 }
 
+func TestSerializationMsgpackVersion057(t *testing.T) {
+	Convey("msgpack serialization should report its size, check its version and read all container types", t, func() {
+		rb := BitmapOf(1, 2, 3, 100000)
+		rb.AddRange(1<<20, 1<<20+70000)
+		rb.RunOptimize()
+		var buf bytes.Buffer
+		n, err := rb.WriteToMsgpack(&buf)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, buf.Len())
+		So(buf.Bytes()[0], ShouldEqual, msgpackFormatVersion)
+		data := buf.Bytes()
+
+		newrb := NewBitmap()
+		n, err = newrb.ReadFromMsgpack(bytes.NewReader(data))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, len(data))
+		So(newrb.Equals(rb), ShouldBeTrue)
+
+		// streams written before the version byte start with the snappy stream identifier
+		newrb = NewBitmap()
+		_, err = newrb.ReadFromMsgpack(bytes.NewReader(data[1:]))
+		So(err, ShouldBeNil)
+		So(newrb.Equals(rb), ShouldBeTrue)
+
+		_, err = newrb.ReadFromMsgpack(bytes.NewReader(append([]byte{msgpackFormatVersion + 1}, data[1:]...)))
+		So(err, ShouldEqual, ErrUnsupportedVersion)
+		_, err = newrb.ReadFromMsgpack(bytes.NewReader(nil))
+		So(err, ShouldEqual, ErrTruncated)
+
+		// a partial write is reported
+		w := &limitedWriter{limit: 10}
+		n, err = rb.WriteToMsgpack(w)
+		So(err, ShouldNotBeNil)
+		So(n, ShouldEqual, w.buf.Len())
+	})
+
+	Convey("msgpack deserialization should convert runContainer32 to runContainer16", t, func() {
+		ra := &NewBitmap().highlowcontainer
+		ra.appendContainer(3, newArrayContainer(), false)
+		bts, err := newRunContainer32TakeOwnership([]interval32{{start: 10, last: 20}, {start: 100, last: 65535}}).MarshalMsg(nil)
+		So(err, ShouldBeNil)
+		ra.conserz = []containerSerz{{t: run32Contype, r: bts}}
+		var buf bytes.Buffer
+		buf.WriteByte(msgpackFormatVersion)
+		So(msgp.Encode(snappy.NewWriter(&buf), ra), ShouldBeNil)
+
+		rb := NewBitmap()
+		_, err = rb.ReadFromMsgpack(&buf)
+		So(err, ShouldBeNil)
+		So(rb.GetCardinality(), ShouldEqual, 11+65436)
+		So(rb.Contains(3<<16+20), ShouldBeTrue)
+		So(rb.Contains(3<<16+21), ShouldBeFalse)
+		So(rb.Validate(), ShouldBeNil)
+
+		bts, err = newRunContainer32Range(10, 1<<16).MarshalMsg(nil)
+		So(err, ShouldBeNil)
+		ra.conserz = []containerSerz{{t: run32Contype, r: bts}}
+		buf.Reset()
+		buf.WriteByte(msgpackFormatVersion)
+		So(msgp.Encode(snappy.NewWriter(&buf), ra), ShouldBeNil)
+		_, err = rb.ReadFromMsgpack(&buf)
+		So(err, ShouldEqual, ErrInvalidFormat)
+	})
+}
+
+func TestSerializationRunContainerReadFrom061(t *testing.T) {
+	Convey("runContainer16 readFrom should report the bytes it read", t, func() {
+		rc := newRunContainer16TakeOwnership([]interval16{{start: 1, last: 3}, {start: 100, last: 65535}})
+		var buf bytes.Buffer
+		n, err := rc.writeTo(&buf)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 2+4*2)
+		buf.WriteString("next container")
+
+		back := newRunContainer16()
+		m, err := back.readFrom(&buf)
+		So(err, ShouldBeNil)
+		So(m, ShouldEqual, n)
+		So(back.equals(rc), ShouldBeTrue)
+		So(buf.String(), ShouldEqual, "next container")
+	})
+}
+
 func TestSerializationRunContainer32Msgpack050(t *testing.T) {
 
 	Convey("runContainer32 writeToMsgpack and readFromMsgpack should save/load data", t, func() {
@@ -667,3 +756,313 @@ func TestSerializationRunContainer32Msgpack050(t *testing.T) {
 
 	})
 }
+
+func TestSerializationFromBuffer053(t *testing.T) {
+	Convey("FromBuffer should match ReadFrom on the Java files", t, func() {
+		for _, fname := range []string{"testdata/bitmapwithoutruns.bin", "testdata/bitmapwithruns.bin"} {
+			buf, err := ioutil.ReadFile(fname)
+			So(err, ShouldBeNil)
+			expected := NewBitmap()
+			_, err = expected.ReadFrom(bytes.NewReader(buf))
+			So(err, ShouldBeNil)
+
+			rb := NewBitmap()
+			n, err := rb.FromBuffer(buf)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, len(buf))
+			So(rb.Equals(expected), ShouldBeTrue)
+			So(rb.GetCardinality(), ShouldEqual, expected.GetCardinality())
+		}
+	})
+
+	Convey("FromBuffer views should not modify the buffer", t, func() {
+		orig := NewBitmap()
+		for k := uint32(0); k < 100000; k += 7 {
+			orig.Add(k)
+		}
+		orig.AddRange(200000, 300000)
+		for k := uint32(400000); k < 420000; k += 2 {
+			orig.Add(k)
+		}
+		orig.RunOptimize()
+		var b bytes.Buffer
+		_, err := orig.WriteTo(&b)
+		So(err, ShouldBeNil)
+		buf := b.Bytes()
+		saved := append([]byte(nil), buf...)
+
+		rb := NewBitmap()
+		_, err = rb.FromBuffer(buf)
+		So(err, ShouldBeNil)
+		So(rb.Equals(orig), ShouldBeTrue)
+		So(rb.Contains(200500), ShouldBeTrue)
+		So(rb.Contains(400001), ShouldBeFalse)
+		So(And(rb, orig).Equals(orig), ShouldBeTrue)
+		So(Or(rb, orig).Equals(orig), ShouldBeTrue)
+		So(rb.ToArray(), ShouldResemble, orig.ToArray())
+
+		rb.Add(1)
+		rb.Remove(0)
+		rb.AddRange(65530, 65600)
+		rb.RemoveRange(400000, 400100)
+		rb.And(BitmapOf(1, 7, 400200, 250000))
+		So(rb.ToArray(), ShouldResemble, []uint32{1, 7, 250000, 400200})
+		So(buf, ShouldResemble, saved)
+
+		view := NewBitmap()
+		_, err = view.FromBuffer(buf)
+		So(err, ShouldBeNil)
+		view.Flip(0, 500000)
+		So(buf, ShouldResemble, saved)
+	})
+
+	Convey("FromBuffer views should not be modified by in-place operations of other bitmaps", t, func() {
+		orig := NewBitmap()
+		for k := uint32(0); k < 100000; k += 7 {
+			orig.Add(k)
+		}
+		var b bytes.Buffer
+		_, err := orig.WriteTo(&b)
+		So(err, ShouldBeNil)
+		buf := b.Bytes()
+		saved := append([]byte(nil), buf...)
+
+		view := NewBitmap()
+		_, err = view.FromBuffer(buf)
+		So(err, ShouldBeNil)
+		bc, isBitmap := view.highlowcontainer.getContainerAtIndex(0).(*bitmapContainer)
+		So(isBitmap, ShouldBeTrue)
+
+		// the argument of an in-place operation belongs to the caller
+		ac := &arrayContainer{[]uint16{1, 2, 3}}
+		answer := ac.ior(bc)
+		So(answer.getCardinality(), ShouldEqual, bc.getCardinality()+3)
+		So(view.Equals(orig), ShouldBeTrue)
+		So(buf, ShouldResemble, saved)
+	})
+
+	Convey("FromBuffer should reject truncated and corrupted buffers", t, func() {
+		orig := BitmapOf(1, 2, 3, 100000)
+		orig.AddRange(500000, 600000)
+		orig.RunOptimize()
+		var b bytes.Buffer
+		_, err := orig.WriteTo(&b)
+		So(err, ShouldBeNil)
+		buf := b.Bytes()
+		for i := 0; i < len(buf); i++ {
+			_, err := NewBitmap().FromBuffer(buf[:i])
+			So(err, ShouldNotBeNil)
+		}
+		_, err = NewBitmap().FromBuffer([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSerializationMalformedCorpus054(t *testing.T) {
+	Convey("ReadFrom and FromBuffer should reject every malformed input of the corpus", t, func() {
+		dir := "testdata/malformed"
+		files, err := ioutil.ReadDir(dir)
+		So(err, ShouldBeNil)
+		So(len(files), ShouldBeGreaterThan, 0)
+		for _, f := range files {
+			name := f.Name()
+			data, err := ioutil.ReadFile(dir + "/" + name)
+			So(err, ShouldBeNil)
+
+			var expected error
+			switch {
+			case strings.HasPrefix(name, "invalid_"):
+				expected = ErrInvalidFormat
+			case strings.HasPrefix(name, "truncated_"):
+				expected = ErrTruncated
+			}
+
+			rb := BitmapOf(1, 2, 3)
+			_, err = rb.ReadFrom(bytes.NewReader(data))
+			So(fmt.Sprint(name, ": ", err), ShouldEqual, fmt.Sprint(name, ": ", expected))
+			view := NewBitmap()
+			_, err = view.FromBuffer(data)
+			So(fmt.Sprint(name, ": ", err), ShouldEqual, fmt.Sprint(name, ": ", expected))
+			if expected == nil {
+				So(rb.Validate(), ShouldBeNil)
+				So(rb.Equals(view), ShouldBeTrue)
+				So(rb.Contains(1), ShouldBeTrue)
+				So(rb.Contains(1<<16+4998), ShouldBeTrue)
+			}
+		}
+	})
+
+	Convey("every truncation of a valid bitmap should give ErrTruncated", t, func() {
+		rb := BitmapOf(1, 2, 3, 1000000)
+		rb.AddRange(1<<16, 1<<16+50000)
+		rb.AddRange(3<<16+7, 3<<16+9)
+		for i := uint32(0); i < 10000; i += 2 {
+			rb.Add(4<<16 + i)
+		}
+		for _, runs := range []bool{false, true} {
+			if runs {
+				rb.RunOptimize()
+			}
+			var buf bytes.Buffer
+			_, err := rb.WriteTo(&buf)
+			So(err, ShouldBeNil)
+			data := buf.Bytes()
+			for i := 0; i < len(data); i++ {
+				_, err = NewBitmap().ReadFrom(bytes.NewReader(data[:i]))
+				So(err, ShouldEqual, ErrTruncated)
+				_, err = NewBitmap().FromBuffer(data[:i])
+				So(err, ShouldEqual, ErrTruncated)
+			}
+		}
+	})
+
+	Convey("corrupted bytes should give an error or a valid bitmap, never a panic", t, func() {
+		rb := BitmapOf(1, 2, 3, 1000000)
+		rb.AddRange(1<<16, 1<<16+50000)
+		rb.AddRange(3<<16+7, 3<<16+9)
+		rb.RunOptimize()
+		var buf bytes.Buffer
+		_, err := rb.WriteTo(&buf)
+		So(err, ShouldBeNil)
+		data := buf.Bytes()
+
+		r := rand.New(rand.NewSource(1))
+		for trial := 0; trial < 2000; trial++ {
+			corrupted := append([]byte(nil), data...)
+			for k := 0; k <= trial%3; k++ {
+				corrupted[r.Intn(len(corrupted))] ^= byte(1 + r.Intn(255))
+			}
+			newrb := NewBitmap()
+			if _, err := newrb.ReadFrom(bytes.NewReader(corrupted)); err == nil {
+				So(newrb.Validate(), ShouldBeNil)
+				So(newrb.GetCardinality(), ShouldEqual, len(newrb.ToArray()))
+			}
+			view := NewBitmap()
+			if _, err := view.FromBuffer(corrupted); err == nil {
+				So(view.Validate(), ShouldBeNil)
+				So(view.GetCardinality(), ShouldEqual, len(view.ToArray()))
+			}
+		}
+	})
+}
+
+func TestSerializationNoRunLayout063(t *testing.T) {
+	Convey("bitmaps without runs should be written like the Java implementation", t, func() {
+		data, err := ioutil.ReadFile("testdata/bitmapwithoutruns.bin")
+		So(err, ShouldBeNil)
+		rb := NewBitmap()
+		_, err = rb.ReadFrom(bytes.NewReader(data))
+		So(err, ShouldBeNil)
+		out, err := rb.MarshalBinary()
+		So(err, ShouldBeNil)
+		So(out, ShouldResemble, data)
+	})
+
+	Convey("bitmaps without runs written with serialCookie should still be read", t, func() {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, uint32(serialCookie|(2-1)<<16))
+		buf.WriteByte(0) // isRun bitset
+		binary.Write(&buf, binary.LittleEndian, []uint16{0, 2 - 1, 3, 1 - 1})
+		binary.Write(&buf, binary.LittleEndian, []uint16{5, 9, 7})
+		rb := NewBitmap()
+		n, err := rb.ReadFrom(bytes.NewReader(buf.Bytes()))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, buf.Len())
+		So(rb.ToArray(), ShouldResemble, []uint32{5, 9, 3<<16 + 7})
+
+		view := NewBitmap()
+		_, err = view.FromBuffer(buf.Bytes())
+		So(err, ShouldBeNil)
+		So(view.Equals(rb), ShouldBeTrue)
+	})
+}
+
+func TestSerializationEmpty055(t *testing.T) {
+	Convey("an empty bitmap should survive a round trip", t, func() {
+		var buf bytes.Buffer
+		n, err := NewBitmap().WriteTo(&buf)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 8)
+		So(NewBitmap().GetSerializedSizeInBytes(), ShouldEqual, 8)
+		rb := BitmapOf(1, 2, 3)
+		_, err = rb.ReadFrom(&buf)
+		So(err, ShouldBeNil)
+		So(rb.IsEmpty(), ShouldBeTrue)
+	})
+}
+
+// limitedWriter accepts limit bytes, then fails
+type limitedWriter struct {
+	buf    bytes.Buffer
+	limit  int
+	writes int
+}
+
+var errWriterFull = errors.New("writer is full")
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if len(p) > w.limit-w.buf.Len() {
+		n, _ := w.buf.Write(p[:w.limit-w.buf.Len()])
+		return n, errWriterFull
+	}
+	return w.buf.Write(p)
+}
+
+// shortWriter silently drops the second half of every write
+type shortWriter struct{}
+
+func (shortWriter) Write(p []byte) (int, error) {
+	return len(p) / 2, nil
+}
+
+func TestSerializationStreaming056(t *testing.T) {
+	Convey("WriteTo should stream the containers and report the bytes written", t, func() {
+		rb := NewBitmap()
+		rb.AddRange(0, 100000)
+		for i := uint32(0); i < 5000; i++ {
+			rb.Add(1<<20 + 3*i)
+			rb.Add(1<<21 + 7*i)
+		}
+		rb.RunOptimize()
+		var buf bytes.Buffer
+		n, err := rb.WriteTo(&buf)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, buf.Len())
+		So(rb.GetSerializedSizeInBytes(), ShouldEqual, buf.Len())
+
+		w := &limitedWriter{limit: buf.Len()}
+		n, err = rb.WriteTo(w)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, buf.Len())
+		// the header, then one write per container
+		So(w.writes, ShouldEqual, 1+rb.highlowcontainer.size())
+		So(bytes.Equal(w.buf.Bytes(), buf.Bytes()), ShouldBeTrue)
+
+		for limit := 0; limit < buf.Len(); limit += 1 + limit/8 {
+			w := &limitedWriter{limit: limit}
+			n, err := rb.WriteTo(w)
+			So(err, ShouldEqual, errWriterFull)
+			So(n, ShouldEqual, limit)
+		}
+
+		_, err = rb.WriteTo(shortWriter{})
+		So(err, ShouldEqual, io.ErrShortWrite)
+	})
+
+	Convey("GetSerializedSizeInBytes should match the written size", t, func() {
+		r := rand.New(rand.NewSource(2024))
+		for trial := 0; trial < 50; trial++ {
+			rb := randomBitmapForValidate(r)
+			var buf bytes.Buffer
+			n, err := rb.WriteTo(&buf)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, buf.Len())
+			So(rb.GetSerializedSizeInBytes(), ShouldEqual, buf.Len())
+			newrb := NewBitmap()
+			_, err = newrb.ReadFrom(&buf)
+			So(err, ShouldBeNil)
+			So(newrb.Equals(rb), ShouldBeTrue)
+		}
+	})
+}
@@ -0,0 +1,304 @@
+package roaring
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// SerializedBitmap gives access to a bitmap serialized in the portable format
+// (see Bitmap.WriteTo) without reading it entirely. Only the header is loaded
+// when it is opened; the containers are read from the underlying io.ReaderAt
+// when a query needs them, so that queries touching a few keys of a large
+// bitmap only read a few containers. A bitmap stored in the middle of a file
+// can be opened through an io.SectionReader.
+type SerializedBitmap struct {
+	r       io.ReaderAt
+	keys    []uint16
+	cards   []int   // cardinality of each container
+	isRun   []bool  // whether each container is a run container
+	offsets []int64 // position of each container in r
+}
+
+// NewSerializedBitmap reads the header of the bitmap serialized in r.
+// It returns ErrInvalidFormat for a malformed header and ErrTruncated for a
+// header that ends too early; the containers are only checked when they are read.
+func NewSerializedBitmap(r io.ReaderAt) (*SerializedBitmap, error) {
+	sb := &SerializedBitmap{r: r}
+	buf, err := sb.readAt(0, 4)
+	if err != nil {
+		return nil, err
+	}
+	pos := int64(4)
+	cookie := binary.LittleEndian.Uint32(buf)
+
+	var size int
+	var isRun []byte
+	haveRunContainers := false
+	if cookie&0x0000FFFF == serialCookie {
+		haveRunContainers = true
+		size = int(cookie>>16) + 1
+		isRun, err = sb.readAt(pos, (size+7)/8)
+		if err != nil {
+			return nil, err
+		}
+		pos += int64(len(isRun))
+	} else if cookie == serialCookieNoRunContainer {
+		buf, err = sb.readAt(pos, 4)
+		if err != nil {
+			return nil, err
+		}
+		pos += 4
+		if binary.LittleEndian.Uint32(buf) > maxCapacity {
+			return nil, ErrInvalidFormat
+		}
+		size = int(binary.LittleEndian.Uint32(buf))
+	} else {
+		return nil, ErrInvalidFormat
+	}
+
+	// descriptive header
+	keycard, err := sb.readAt(pos, 4*size)
+	if err != nil {
+		return nil, err
+	}
+	pos += int64(len(keycard))
+	if !validKeycard(keycard) {
+		return nil, ErrInvalidFormat
+	}
+	sb.keys = make([]uint16, size)
+	sb.cards = make([]int, size)
+	sb.isRun = make([]bool, size)
+	for i := 0; i < size; i++ {
+		sb.keys[i] = binary.LittleEndian.Uint16(keycard[4*i:])
+		sb.cards[i] = int(binary.LittleEndian.Uint16(keycard[4*i+2:])) + 1
+		sb.isRun[i] = haveRunContainers && isRun[i/8]&(1<<uint(i%8)) != 0
+	}
+
+	// offset header, computed from the sizes of the containers when absent
+	sb.offsets = make([]int64, size)
+	if !haveRunContainers || size >= noOffsetThreshold {
+		offsets, err := sb.readAt(pos, 4*size)
+		if err != nil {
+			return nil, err
+		}
+		pos += int64(len(offsets))
+		for i := range sb.offsets {
+			sb.offsets[i] = int64(binary.LittleEndian.Uint32(offsets[4*i:]))
+		}
+		if size > 0 && sb.offsets[0] != pos {
+			return nil, ErrInvalidFormat
+		}
+		for i := 1; i < size; i++ {
+			// the size of a run container is only known once it is read
+			if sb.isRun[i-1] && sb.offsets[i] < sb.offsets[i-1]+2 ||
+				!sb.isRun[i-1] && sb.offsets[i] != sb.offsets[i-1]+int64(getSizeInBytesFromCardinality(sb.cards[i-1])) {
+				return nil, ErrInvalidFormat
+			}
+		}
+	} else {
+		for i := range sb.offsets {
+			sb.offsets[i] = pos
+			if sb.isRun[i] {
+				buf, err = sb.readAt(pos, 2)
+				if err != nil {
+					return nil, err
+				}
+				pos += 2 + 4*int64(binary.LittleEndian.Uint16(buf))
+			} else {
+				pos += int64(getSizeInBytesFromCardinality(sb.cards[i]))
+			}
+		}
+	}
+	return sb, nil
+}
+
+// readAt reads n bytes at offset off of the underlying reader
+func (sb *SerializedBitmap) readAt(off int64, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	nr, err := sb.r.ReadAt(buf, off)
+	if nr == n {
+		// ReadAt may report io.EOF along with the last bytes
+		return buf, nil
+	}
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, readError(err)
+}
+
+// container reads and checks the i-th container
+func (sb *SerializedBitmap) container(i int) (container, error) {
+	card := sb.cards[i]
+	off := sb.offsets[i]
+	if sb.isRun[i] {
+		buf, err := sb.readAt(off, 2)
+		if err != nil {
+			return nil, err
+		}
+		nr := int(binary.LittleEndian.Uint16(buf))
+		if i+1 < len(sb.offsets) && sb.offsets[i+1] != off+2+4*int64(nr) {
+			return nil, ErrInvalidFormat
+		}
+		buf, err = sb.readAt(off+2, 4*nr)
+		if err != nil {
+			return nil, err
+		}
+		iv, rcard, err := decodeRuns16(buf)
+		if err != nil {
+			return nil, err
+		}
+		if rcard != int64(card) {
+			return nil, ErrInvalidFormat
+		}
+		return &runContainer16{iv: iv, card: rcard}, nil
+	}
+	buf, err := sb.readAt(off, getSizeInBytesFromCardinality(card))
+	if err != nil {
+		return nil, err
+	}
+	if card > arrayDefaultMaxSize {
+		bitmap := byteSliceAsUint64Slice(buf)
+		if int(popcntSlice(bitmap)) != card {
+			return nil, ErrInvalidFormat
+		}
+		return &bitmapContainer{cardinality: card, bitmap: bitmap}, nil
+	}
+	content := byteSliceAsUint16Slice(buf)
+	if !validArrayContent(content) {
+		return nil, ErrInvalidFormat
+	}
+	return &arrayContainer{content}, nil
+}
+
+// GetCardinality returns the number of integers contained in the bitmap, it is read from the header
+func (sb *SerializedBitmap) GetCardinality() uint64 {
+	answer := uint64(0)
+	for _, card := range sb.cards {
+		answer += uint64(card)
+	}
+	return answer
+}
+
+// IsEmpty returns true if the bitmap is empty
+func (sb *SerializedBitmap) IsEmpty() bool {
+	return len(sb.keys) == 0
+}
+
+// Contains returns true if the integer is contained in the bitmap,
+// at most one container is read
+func (sb *SerializedBitmap) Contains(x uint32) (bool, error) {
+	i := binarySearch(sb.keys, highbits(x))
+	if i < 0 {
+		return false, nil
+	}
+	c, err := sb.container(i)
+	if err != nil {
+		return false, err
+	}
+	return c.contains(lowbits(x)), nil
+}
+
+// RangeCardinality returns the number of integers in [start, end) that are in the bitmap.
+// Only the containers at the boundaries of the range are read, the cardinality
+// of the others is known from the header.
+func (sb *SerializedBitmap) RangeCardinality(start, end uint64) (uint64, error) {
+	if end > MaxUint32+1 {
+		end = MaxUint32 + 1
+	}
+	if start >= end {
+		return 0, nil
+	}
+	hbStart, lbStart := highbits(uint32(start)), int(lowbits(uint32(start)))
+	hbLast, lbLast := highbits(uint32(end-1)), int(lowbits(uint32(end-1)))
+
+	i := binarySearch(sb.keys, hbStart)
+	if i < 0 {
+		i = -i - 1
+	}
+	answer := uint64(0)
+	for ; i < len(sb.keys) && sb.keys[i] <= hbLast; i++ {
+		first, endx := 0, maxLowBit+1
+		if sb.keys[i] == hbStart {
+			first = lbStart
+		}
+		if sb.keys[i] == hbLast {
+			endx = lbLast + 1
+		}
+		if first == 0 && endx == maxLowBit+1 {
+			answer += uint64(sb.cards[i])
+			continue
+		}
+		c, err := sb.container(i)
+		if err != nil {
+			return 0, err
+		}
+		answer += uint64(c.getCardinalityInRange(first, endx))
+	}
+	return answer, nil
+}
+
+// intersect calls f with the containers of sb and x2 that share a key,
+// stopping early if f returns false. Only the containers of sb whose key
+// is in x2 are read.
+func (sb *SerializedBitmap) intersect(x2 *Bitmap, f func(key uint16, c1, c2 container) bool) error {
+	pos1 := 0
+	pos2 := 0
+	length1 := len(sb.keys)
+	length2 := x2.highlowcontainer.size()
+	for pos1 < length1 && pos2 < length2 {
+		s1 := sb.keys[pos1]
+		s2 := x2.highlowcontainer.getKeyAtIndex(pos2)
+		if s1 < s2 {
+			pos1++
+		} else if s1 > s2 {
+			pos2 = x2.highlowcontainer.advanceUntil(s1, pos2)
+		} else {
+			c1, err := sb.container(pos1)
+			if err != nil {
+				return err
+			}
+			if !f(s1, c1, x2.highlowcontainer.getContainerAtIndex(pos2)) {
+				return nil
+			}
+			pos1++
+			pos2++
+		}
+	}
+	return nil
+}
+
+// And computes the intersection between the serialized bitmap and x2, x2 is not modified
+func (sb *SerializedBitmap) And(x2 *Bitmap) (*Bitmap, error) {
+	answer := NewBitmap()
+	err := sb.intersect(x2, func(key uint16, c1, c2 container) bool {
+		c := c1.and(c2)
+		if c.getCardinality() > 0 {
+			answer.highlowcontainer.appendContainer(key, c, false)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// AndCardinality returns the cardinality of the intersection between the serialized bitmap and x2
+func (sb *SerializedBitmap) AndCardinality(x2 *Bitmap) (uint64, error) {
+	answer := uint64(0)
+	err := sb.intersect(x2, func(key uint16, c1, c2 container) bool {
+		answer += uint64(c1.andCardinality(c2))
+		return true
+	})
+	return answer, err
+}
+
+// Intersects checks whether the serialized bitmap and x2 intersect
+func (sb *SerializedBitmap) Intersects(x2 *Bitmap) (bool, error) {
+	answer := false
+	err := sb.intersect(x2, func(key uint16, c1, c2 container) bool {
+		answer = c1.intersects(c2)
+		return !answer
+	})
+	return answer, err
+}
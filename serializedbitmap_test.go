@@ -0,0 +1,145 @@
+package roaring
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// countingReaderAt counts the calls to ReadAt
+type countingReaderAt struct {
+	r     io.ReaderAt
+	reads int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	return c.r.ReadAt(p, off)
+}
+
+func serializedBitmapOf(rb *Bitmap) (*SerializedBitmap, []byte) {
+	buf, err := rb.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	sb, err := NewSerializedBitmap(bytes.NewReader(buf))
+	if err != nil {
+		panic(err)
+	}
+	return sb, buf
+}
+
+func TestSerializedBitmap(t *testing.T) {
+	Convey("queries on a serialized bitmap should match the in-memory bitmap", t, func() {
+		r := rand.New(rand.NewSource(2025))
+		for trial := 0; trial < 100; trial++ {
+			rb := randomBitmapForValidate(r)
+			other := randomBitmapForValidate(r)
+			sb, _ := serializedBitmapOf(rb)
+			So(sb.GetCardinality(), ShouldEqual, rb.GetCardinality())
+			So(sb.IsEmpty(), ShouldEqual, rb.IsEmpty())
+			for i := 0; i < 20; i++ {
+				x := uint32(r.Intn(9 << 16))
+				found, err := sb.Contains(x)
+				So(err, ShouldBeNil)
+				So(found, ShouldEqual, rb.Contains(x))
+				end := uint64(x) + uint64(r.Intn(3<<16))
+				card, err := sb.RangeCardinality(uint64(x), end)
+				So(err, ShouldBeNil)
+				So(card, ShouldEqual, rb.RangeCardinality(uint64(x), end))
+			}
+			and, err := sb.And(other)
+			So(err, ShouldBeNil)
+			So(and.Equals(And(rb, other)), ShouldBeTrue)
+			card, err := sb.AndCardinality(other)
+			So(err, ShouldBeNil)
+			So(card, ShouldEqual, rb.AndCardinality(other))
+			intersects, err := sb.Intersects(other)
+			So(err, ShouldBeNil)
+			So(intersects, ShouldEqual, rb.Intersects(other))
+		}
+	})
+
+	Convey("containers should only be read when needed", t, func() {
+		rb := NewBitmap()
+		for i := uint32(0); i < 100; i++ {
+			rb.Add(i<<16 + i)
+		}
+		rb.AddRange(200<<16, 201<<16)
+		buf, err := rb.MarshalBinary()
+		So(err, ShouldBeNil)
+		cr := &countingReaderAt{r: bytes.NewReader(buf)}
+		sb, err := NewSerializedBitmap(cr)
+		So(err, ShouldBeNil)
+
+		cr.reads = 0
+		found, err := sb.Contains(42<<16 + 42)
+		So(err, ShouldBeNil)
+		So(found, ShouldBeTrue)
+		So(cr.reads, ShouldEqual, 1)
+
+		cr.reads = 0
+		found, err = sb.Contains(150 << 16)
+		So(err, ShouldBeNil)
+		So(found, ShouldBeFalse)
+		So(cr.reads, ShouldEqual, 0)
+
+		cr.reads = 0
+		card, err := sb.RangeCardinality(0, 100<<16)
+		So(err, ShouldBeNil)
+		So(card, ShouldEqual, 100)
+		So(cr.reads, ShouldEqual, 0)
+
+		cr.reads = 0
+		and, err := sb.And(BitmapOf(3<<16+3, 200<<16+5))
+		So(err, ShouldBeNil)
+		So(and.ToArray(), ShouldResemble, []uint32{3<<16 + 3, 200<<16 + 5})
+		// a run container is read in two steps, its size then its runs
+		So(cr.reads, ShouldEqual, 3)
+	})
+
+	Convey("a few run containers are stored without offsets", t, func() {
+		rb := NewBitmap()
+		rb.AddRange(10, 1000)
+		rb.Add(1 << 16)
+		rb.AddRange(5<<16, 5<<16+100)
+		rb.RunOptimize()
+		sb, _ := serializedBitmapOf(rb)
+		for _, x := range []uint32{10, 999, 1000, 1 << 16, 5<<16 + 99, 5<<16 + 100} {
+			found, err := sb.Contains(x)
+			So(err, ShouldBeNil)
+			So(found, ShouldEqual, rb.Contains(x))
+		}
+	})
+
+	Convey("truncated or corrupted input should give an error", t, func() {
+		rb := NewBitmap()
+		rb.AddRange(0, 70000)
+		for i := uint32(0); i < 6000; i++ {
+			rb.Add(3<<16 + 2*i)
+		}
+		rb.Add(5 << 16)
+		rb.Add(5<<16 + 1)
+		_, buf := serializedBitmapOf(rb)
+		for n := 0; n < len(buf); n++ {
+			sb, err := NewSerializedBitmap(bytes.NewReader(buf[:n]))
+			if err == nil {
+				_, err = sb.And(rb)
+			}
+			So(err, ShouldEqual, ErrTruncated)
+		}
+
+		corrupted := append([]byte(nil), buf...)
+		corrupted[len(corrupted)-2] = 0 // the last array container is no longer sorted
+		sb, err := NewSerializedBitmap(bytes.NewReader(corrupted))
+		So(err, ShouldBeNil)
+		_, err = sb.Contains(5 << 16)
+		So(err, ShouldEqual, ErrInvalidFormat)
+
+		_, err = NewSerializedBitmap(bytes.NewReader([]byte{1, 2, 3, 4}))
+		So(err, ShouldEqual, ErrInvalidFormat)
+	})
+}
@@ -13,6 +13,7 @@ func equal(a, b []uint16) bool {
 }
 
 func difference(set1 []uint16, set2 []uint16, buffer []uint16) int {
+	buffer = buffer[:cap(buffer)]
 	if 0 == len(set2) {
 		for k := 0; k < len(set1); k++ {
 			buffer[k] = set1[k]
@@ -25,7 +26,6 @@ func difference(set1 []uint16, set2 []uint16, buffer []uint16) int {
 	pos := 0
 	k1 := 0
 	k2 := 0
-	buffer = buffer[:cap(buffer)]
 	s1 := set1[k1]
 	s2 := set2[k2]
 	for {
@@ -419,6 +419,44 @@ mainwhile:
 	return pos
 }
 
+// intersection2by2Cardinality returns the size of the intersection of two sorted sets
+func intersection2by2Cardinality(set1 []uint16, set2 []uint16) int {
+	if len(set1) > len(set2) {
+		set1, set2 = set2, set1
+	}
+	answer := 0
+	if len(set1)*64 < len(set2) {
+		// galloping in the large set
+		pos := -1
+		for _, v := range set1 {
+			pos = advanceUntil(set2, pos, len(set2), v)
+			if pos == len(set2) {
+				break
+			}
+			if set2[pos] == v {
+				answer++
+			} else {
+				// set2[pos] may be the next value of set1
+				pos--
+			}
+		}
+		return answer
+	}
+	k1, k2 := 0, 0
+	for k1 < len(set1) && k2 < len(set2) {
+		if set1[k1] < set2[k2] {
+			k1++
+		} else if set1[k1] > set2[k2] {
+			k2++
+		} else {
+			answer++
+			k1++
+			k2++
+		}
+	}
+	return answer
+}
+
 func binarySearch(array []uint16, ikey uint16) int {
 	low := 0
 	high := len(array) - 1
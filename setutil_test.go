@@ -109,6 +109,25 @@ func TestSetUtilIntersection2(t *testing.T) {
 	}
 }
 
+func TestSetUtilIntersectionCardinality(t *testing.T) {
+	data1 := []uint16{0, 2, 4, 6, 8, 10, 12, 14, 16, 18}
+	data2 := []uint16{0, 3, 6, 9, 12, 15, 18}
+	if n := intersection2by2Cardinality(data1, data2); n != 4 {
+		t.Errorf("Intersection cardinality is broken: %d", n)
+	}
+	large := make([]uint16, 1000)
+	for i := range large {
+		large[i] = uint16(3 * i)
+	}
+	// the small set is galloped through the large one
+	if n := intersection2by2Cardinality(data1, large); n != 4 {
+		t.Errorf("Galloping intersection cardinality is broken: %d", n)
+	}
+	if n := intersection2by2Cardinality(large, []uint16{2997, 2998, 3000}); n != 1 {
+		t.Errorf("Galloping intersection cardinality is broken: %d", n)
+	}
+}
+
 func TestSetUtilBinarySearch(t *testing.T) {
 	data := make([]uint16, 256)
 	for i := range data {
@@ -55,6 +55,36 @@ func numberOfTrailingZeros(i uint64) int {
 	return int(n - int64(uint64(x<<1)>>63))
 }
 
+// should be replaced with optimized assembly instructions
+func numberOfLeadingZeros(i uint64) int {
+	if i == 0 {
+		return 64
+	}
+	n := 1
+	x := uint32(i >> 32)
+	if x == 0 {
+		n += 32
+		x = uint32(i)
+	}
+	if x>>16 == 0 {
+		n += 16
+		x <<= 16
+	}
+	if x>>24 == 0 {
+		n += 8
+		x <<= 8
+	}
+	if x>>28 == 0 {
+		n += 4
+		x <<= 4
+	}
+	if x>>30 == 0 {
+		n += 2
+		x <<= 2
+	}
+	return n - int(x>>31)
+}
+
 func fill(arr []uint64, val uint64) {
 	for i := range arr {
 		arr[i] = val
@@ -195,6 +225,7 @@ func setBitmapRangeAndCardinalityChange(bitmap []uint64, start int, end int) int
 	return int(after - before)
 }
 
+// wordCardinalityForBitmapRange returns the number of bits set in [start, end)
 func wordCardinalityForBitmapRange(bitmap []uint64, start int, end int) uint64 {
 	answer := uint64(0)
 	if start >= end {
@@ -202,9 +233,14 @@ func wordCardinalityForBitmapRange(bitmap []uint64, start int, end int) uint64 {
 	}
 	firstword := start / 64
 	endword := (end - 1) / 64
-	for i := firstword; i <= endword; i++ {
+	if firstword == endword {
+		return popcount(bitmap[firstword] & (^uint64(0) << uint(start%64)) & (^uint64(0) >> (uint(-end) % 64)))
+	}
+	answer = popcount(bitmap[firstword] & (^uint64(0) << uint(start%64)))
+	for i := firstword + 1; i < endword; i++ {
 		answer += popcount(bitmap[i])
 	}
+	answer += popcount(bitmap[endword] & (^uint64(0) >> (uint(-end) % 64)))
 	return answer
 }
 